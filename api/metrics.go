@@ -0,0 +1,76 @@
+package api
+
+import (
+    "fmt"
+    "io"
+    "sort"
+    "strings"
+
+    "github.com/bonniegachiengu/sustena_platforms/entropy/network"
+)
+
+// counterMetric is one series family derived from network.Counters
+type counterMetric struct {
+    name  string
+    kind  string
+    help  string
+    value func(c network.Counters) string
+    // direction labels the series when it is one half of an in/out pair
+    direction string
+}
+
+var counterMetrics = []counterMetric{
+    {"messages_total", "counter", "Messages exchanged.", func(c network.Counters) string { return fmt.Sprint(c.MessagesIn) }, "in"},
+    {"messages_total", "counter", "Messages exchanged.", func(c network.Counters) string { return fmt.Sprint(c.MessagesOut) }, "out"},
+    {"bytes_total", "counter", "Bytes exchanged.", func(c network.Counters) string { return fmt.Sprint(c.BytesIn) }, "in"},
+    {"bytes_total", "counter", "Bytes exchanged.", func(c network.Counters) string { return fmt.Sprint(c.BytesOut) }, "out"},
+    {"errors_total", "counter", "Failed or rejected messages.", func(c network.Counters) string { return fmt.Sprint(c.Errors) }, ""},
+    {"latency_seconds", "gauge", "Moving average of request round trips.", func(c network.Counters) string { return fmt.Sprint(c.Latency.Seconds()) }, ""},
+}
+
+// writePrometheus renders network metrics in the Prometheus text format
+func writePrometheus(w io.Writer, peers int, m network.Metrics) {
+    fmt.Fprintln(w, "# HELP sustena_p2p_peers Peers that completed the handshake.")
+    fmt.Fprintln(w, "# TYPE sustena_p2p_peers gauge")
+    fmt.Fprintf(w, "sustena_p2p_peers %d\n", peers)
+
+    protocols := make(map[string]network.Counters, len(m.Protocols))
+    for proto, c := range m.Protocols {
+        protocols[proto] = c
+    }
+    peerCounters := make(map[string]network.Counters, len(m.Peers))
+    for id, c := range m.Peers {
+        peerCounters[id.String()] = c
+    }
+
+    writeFamilies(w, "sustena_p2p_protocol_", "protocol", protocols)
+    writeFamilies(w, "sustena_p2p_peer_", "peer", peerCounters)
+}
+
+func writeFamilies(w io.Writer, prefix, label string, series map[string]network.Counters) {
+    keys := make([]string, 0, len(series))
+    for k := range series {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+
+    written := make(map[string]bool)
+    for _, metric := range counterMetrics {
+        name := prefix + metric.name
+        if !written[name] {
+            fmt.Fprintf(w, "# HELP %s %s\n", name, metric.help)
+            fmt.Fprintf(w, "# TYPE %s %s\n", name, metric.kind)
+            written[name] = true
+        }
+        for _, k := range keys {
+            labels := fmt.Sprintf(`%s="%s"`, label, labelEscaper.Replace(k))
+            if metric.direction != "" {
+                labels += fmt.Sprintf(`,direction="%s"`, metric.direction)
+            }
+            fmt.Fprintf(w, "%s{%s} %s\n", name, labels, metric.value(series[k]))
+        }
+    }
+}
+
+// labelEscaper escapes label values as the text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
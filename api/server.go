@@ -1,29 +1,110 @@
 package api
 
-// Add API server implementation here
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log"
+    "net"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/bonniegachiengu/sustena_platforms/entropy/network"
+)
+
+// shutdownTimeout bounds how long Shutdown waits for open requests
+const shutdownTimeout = 5 * time.Second
+
+// NetworkInfo is the view of the P2P network the API serves
+type NetworkInfo interface {
+    Peers() []network.PeerInfo
+    Metrics() network.Metrics
+}
 
 type Server struct {
-    // Server fields
+    config  APIConfig
+    network NetworkInfo
+    http    *http.Server
 }
 
-// Define APIConfig struct
 type APIConfig struct {
-    // Add necessary fields here
-    // For example:
-    // Port int
-    // DatabaseURL string
+    Port int
+    Host string
 }
 
-func NewServer(config APIConfig) *Server {
-    return &Server{
-        // Initialize server with config
+// NewServer creates an API server for config. network may be nil, in which
+// case the network endpoints report that the network is unavailable.
+func NewServer(config APIConfig, network NetworkInfo) *Server {
+    s := &Server{
+        config:  config,
+        network: network,
+    }
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/metrics", s.handleMetrics)
+    mux.HandleFunc("/api/network/peers", s.handlePeers)
+    mux.HandleFunc("/api/network/metrics", s.handleNetworkMetrics)
+
+    s.http = &http.Server{
+        Addr:              net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+        Handler:           mux,
+        ReadHeaderTimeout: 10 * time.Second,
     }
+    return s
 }
 
+// Start serves the API until Shutdown is called
 func (s *Server) Start() {
-    // Start the server
+    log.Printf("API server listening on %s", s.http.Addr)
+    if err := s.http.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+        log.Printf("API server stopped: %v", err)
+    }
 }
 
 func (s *Server) Shutdown() {
-    // Shutdown the server
+    ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+    defer cancel()
+    s.http.Shutdown(ctx)
+}
+
+// available reports whether network endpoints can be served, answering
+// the request itself if not
+func (s *Server) available(w http.ResponseWriter, r *http.Request) bool {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return false
+    }
+    if s.network == nil {
+        http.Error(w, "network unavailable", http.StatusServiceUnavailable)
+        return false
+    }
+    return true
+}
+
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+    if s.available(w, r) {
+        writeJSON(w, s.network.Peers())
+    }
+}
+
+func (s *Server) handleNetworkMetrics(w http.ResponseWriter, r *http.Request) {
+    if s.available(w, r) {
+        writeJSON(w, s.network.Metrics())
+    }
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+    if !s.available(w, r) {
+        return
+    }
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    writePrometheus(w, len(s.network.Peers()), s.network.Metrics())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    if err := json.NewEncoder(w).Encode(v); err != nil {
+        log.Printf("Failed to write API response: %v", err)
+    }
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/bonniegachiengu/sustena_platforms/entropy/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// command is a CLI subcommand run instead of starting the node
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"identity": {
+		usage: "print this node's peer ID and shareable multiaddrs",
+		run:   runIdentity,
+	},
+	"psk": {
+		usage: "write a new private network key to the given file",
+		run:   runPSK,
+	},
+	"asm": {
+		usage: "assemble a Symmetry assembly file to bytecode",
+		run:   runAsm,
+	},
+	"disasm": {
+		usage: "print a bytecode file as Symmetry assembly",
+		run:   runDisasm,
+	},
+	"trace": {
+		usage: "run a bytecode file and print a JSON trace of each instruction",
+		run:   runTrace,
+	},
+	"debug": {
+		usage: "step through a bytecode file with breakpoints",
+		run:   runDebug,
+	},
+}
+
+// runCommand runs the subcommand name, or returns an error listing the
+// available commands
+func runCommand(name string, args []string) error {
+	cmd, exists := commands[name]
+	if !exists {
+		return fmt.Errorf("unknown command %q\n\n%s", name, usage())
+	}
+	return cmd.run(args)
+}
+
+func usage() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	text := "Usage: sustena [command]\n\nWithout a command the node is started.\n\nCommands:\n"
+	for _, name := range names {
+		text += fmt.Sprintf("  %-10s %s\n", name, commands[name].usage)
+	}
+	return text
+}
+
+func runIdentity(args []string) error {
+	cfg := network.LoadNetworkConfig()
+	key, err := network.LoadIdentity(cfg.DataDir)
+	if err != nil {
+		return err
+	}
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	addrs, err := network.NodeAddrs(cfg.ListenAddr, id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Peer ID: %s\n", id)
+	for _, addr := range addrs {
+		fmt.Println(addr)
+	}
+	return nil
+}
+
+func runPSK(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: sustena psk <file>")
+	}
+	key, err := network.GeneratePSK()
+	if err != nil {
+		return err
+	}
+	// O_EXCL so an existing network's key is never overwritten
+	f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote private network key to %s\n", args[0])
+	fmt.Println("Copy it to every member and set pskFile in config/config.yaml")
+	return nil
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"log"
+
 	"github.com/spf13/viper"
 )
 
@@ -12,10 +14,32 @@ type Config struct {
 }
 
 type NetworkConfig struct {
-	ListenAddr     string   `json:"listen_addr"`
-	BootstrapPeers []string `json:"bootstrap_peers"`
+	ListenAddr     string   `json:"listen_addr" mapstructure:"listen_addr"`
+	BootstrapPeers []string `json:"bootstrap_peers" mapstructure:"bootstrap_peers"`
 	Port     int    `mapstructure:"port"`
 	Protocol string `mapstructure:"protocol"`
+	ChainID  string `mapstructure:"chainId"`
+	DataDir  string `mapstructure:"dataDir"`
+
+	// Connection manager water marks: once HighWater connections are open,
+	// the lowest scoring peers are trimmed back to LowWater
+	ConnLowWater  int `mapstructure:"connLowWater"`
+	ConnHighWater int `mapstructure:"connHighWater"`
+	// Inbound messages per second allowed from a single peer, and burst
+	PeerRateLimit float64 `mapstructure:"peerRateLimit"`
+	PeerRateBurst int     `mapstructure:"peerRateBurst"`
+
+	// Discovery: mDNS finds peers on the LAN, the DHT across networks.
+	// Both default to on; TargetPeers is the peer count discovery maintains.
+	EnableMDNS  bool `mapstructure:"enableMdns"`
+	EnableDHT   bool `mapstructure:"enableDht"`
+	TargetPeers int  `mapstructure:"targetPeers"`
+
+	// Private network mode: PSKFile names a libp2p swarm key shared by
+	// every member, and a non-empty AllowedPeers limits connections to the
+	// listed peer IDs
+	PSKFile      string   `mapstructure:"pskFile"`
+	AllowedPeers []string `mapstructure:"allowedPeers"`
 }
 
 type APIConfig struct {
@@ -32,6 +56,10 @@ func LoadConfig() (*Config, error) {
 	viper.AddConfigPath("./config")     // Look for config in a subdirectory named "config"
 	viper.AddConfigPath("../config")    // Look for config in the parent directory's config folder
 
+	defaults := DefaultNetworkConfig()
+	viper.SetDefault("networkConfig.enableMdns", defaults.EnableMDNS)
+	viper.SetDefault("networkConfig.enableDht", defaults.EnableDHT)
+
 	err := viper.ReadInConfig()         // Find and read the config file
 	if err != nil {                     // Handle errors reading the config file
 		return nil, fmt.Errorf("fatal error config file: %w", err)
@@ -46,13 +74,24 @@ func LoadConfig() (*Config, error) {
 	return &config, nil
 }
 
+// DefaultNetworkConfig holds the network settings that default to
+// something other than their zero value
+func DefaultNetworkConfig() NetworkConfig {
+	return NetworkConfig{
+		EnableMDNS: true,
+		EnableDHT:  true,
+	}
+}
+
+// GetNetworkConfig returns the loaded network configuration, or
+// DefaultNetworkConfig if the config file can't be loaded
 func GetNetworkConfig() NetworkConfig {
 	if globalConfig == nil {
 		var err error
 		globalConfig, err = LoadConfig()
 		if err != nil {
-			// Handle error, perhaps log it and return a default config
-			return NetworkConfig{}
+			log.Printf("Using default network configuration: %v", err)
+			return DefaultNetworkConfig()
 		}
 	}
 	return globalConfig.NetworkConfig
@@ -2,6 +2,7 @@ package compiler
 
 import (
     "github.com/bonniegachiengu/sustena_platforms/embroidery/parser"
+    "github.com/bonniegachiengu/sustena_platforms/symmetry/vm"
 )
 
 type Compiler struct {
@@ -12,9 +13,13 @@ func NewCompiler() *Compiler {
     return &Compiler{}
 }
 
+// Compile translates an AST to Symmetry bytecode
 func (c *Compiler) Compile(ast *parser.AST) ([]byte, error) {
-    // Implement the compilation logic here
-    // This is a placeholder implementation
-    bytecode := []byte{0x01, 0x02, 0x03} // Example bytecode
-    return bytecode, nil
+    return vm.Encode(c.generate(ast))
+}
+
+// generate emits the instructions for ast. The parser doesn't produce any
+// nodes yet, so every contract compiles to a program that stops at once.
+func (c *Compiler) generate(ast *parser.AST) []vm.Instruction {
+    return []vm.Instruction{{OpCode: vm.STOP}}
 }
@@ -17,10 +17,28 @@ type Block struct {
 	Stake        int64
 }
 
+// BlockHeader is a block without its transactions
+type BlockHeader struct {
+	Index     int64
+	Timestamp int64
+	PrevHash  string
+	Hash      string
+	Validator string
+	Stake     int64
+}
+
 type Transaction struct {
 	From   string
 	To     string
 	Amount int64 // Amount in Joules
+	Nonce  int64 // Distinguishes otherwise identical transfers
+}
+
+// Hash identifies a transaction on the network and in the mempool
+func (tx Transaction) Hash() string {
+	record := tx.From + tx.To + strconv.FormatInt(tx.Amount, 10) + strconv.FormatInt(tx.Nonce, 10)
+	hashed := sha256.Sum256([]byte(record))
+	return hex.EncodeToString(hashed[:])
 }
 
 func NewBlock(index int64, transactions []Transaction, prevHash string, validator string, stake int64) *Block {
@@ -36,10 +54,35 @@ func NewBlock(index int64, transactions []Transaction, prevHash string, validato
 	return block
 }
 
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{
+		Index:     b.Index,
+		Timestamp: b.Timestamp,
+		PrevHash:  b.PrevHash,
+		Hash:      b.Hash,
+		Validator: b.Validator,
+		Stake:     b.Stake,
+	}
+}
+
+// BlockFromHeader assembles a block from a header and its transactions.
+// The result only matches the header if CalculateHash equals its Hash.
+func BlockFromHeader(h BlockHeader, transactions []Transaction) *Block {
+	return &Block{
+		Index:        h.Index,
+		Timestamp:    h.Timestamp,
+		Transactions: transactions,
+		PrevHash:     h.PrevHash,
+		Hash:         h.Hash,
+		Validator:    h.Validator,
+		Stake:        h.Stake,
+	}
+}
+
 func (b *Block) CalculateHash() string {
 	record := strconv.FormatInt(b.Index, 10) + strconv.FormatInt(b.Timestamp, 10) + b.PrevHash + b.Validator + strconv.FormatInt(b.Stake, 10)
 	for _, tx := range b.Transactions {
-		record += tx.From + tx.To + strconv.FormatInt(tx.Amount, 10)
+		record += tx.Hash()
 	}
 	h := sha256.New()
 	h.Write([]byte(record))
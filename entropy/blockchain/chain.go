@@ -2,44 +2,88 @@ package blockchain
 
 import (
 	"errors"
+	"fmt"
 	"sync"
 	"crypto/sha256"
 	"encoding/hex"
+
+	"github.com/bonniegachiengu/sustena_platforms/symmetry/vm"
 )
 
 type Blockchain struct {
 	Chain  []*Block
 	mu     sync.Mutex
+
+	// txIndex maps included transaction hashes to their block index
+	txIndex   map[string]int64
+	listeners []func(*Block)
 }
 
 func NewBlockchain() *Blockchain {
 	return &Blockchain{
-		Chain: []*Block{GenesisBlock()},
+		Chain:   []*Block{GenesisBlock()},
+		txIndex: make(map[string]int64),
 	}
 }
 
+// GenesisBlock returns the first block of the chain. Its timestamp is
+// fixed so that every node derives the same genesis hash.
 func GenesisBlock() *Block {
-	return NewBlock(0, []Transaction{}, "", "GenesisValidator", 0)
+	block := &Block{
+		Index:        0,
+		Timestamp:    0,
+		Transactions: []Transaction{},
+		Validator:    "GenesisValidator",
+	}
+	block.Hash = block.CalculateHash()
+	return block
 }
 
 func (bc *Blockchain) AddBlock(block *Block) error {
 	bc.mu.Lock()
-	defer bc.mu.Unlock()
 
 	if len(bc.Chain) > 0 {
 		lastBlock := bc.Chain[len(bc.Chain)-1]
 		if block.PrevHash != lastBlock.Hash {
+			bc.mu.Unlock()
 			return errors.New("invalid previous hash")
 		}
 		if block.Index != lastBlock.Index+1 {
+			bc.mu.Unlock()
 			return errors.New("invalid block index")
 		}
 	}
 
 	bc.Chain = append(bc.Chain, block)
+	for _, tx := range block.Transactions {
+		bc.txIndex[tx.Hash()] = block.Index
+	}
+	listeners := bc.listeners
+	bc.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(block)
+	}
 	return nil
 }
 
+// OnBlockAdded registers fn to be called after each block is appended
+func (bc *Blockchain) OnBlockAdded(fn func(*Block)) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.listeners = append(bc.listeners, fn)
+}
+
+// HasTransaction reports whether a transaction is already in a block
+func (bc *Blockchain) HasTransaction(hash string) bool {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	_, exists := bc.txIndex[hash]
+	return exists
+}
+
 func (bc *Blockchain) GetLastBlock() *Block {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
@@ -50,7 +94,57 @@ func (bc *Blockchain) GetLastBlock() *Block {
 	return nil
 }
 
+// Height returns the index of the last block in the chain
+func (bc *Blockchain) Height() int64 {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	return bc.Chain[len(bc.Chain)-1].Index
+}
+
+// GenesisHash returns the hash of the first block in the chain
+func (bc *Blockchain) GenesisHash() string {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	return bc.Chain[0].Hash
+}
+
+// GetBlock returns the block at index, or nil if the chain is shorter
+func (bc *Blockchain) GetBlock(index int64) *Block {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if index < 0 || index >= int64(len(bc.Chain)) {
+		return nil
+	}
+	return bc.Chain[index]
+}
+
+// GetBlocks returns the blocks with indexes in [from, to], truncated to the
+// blocks the chain actually has
+func (bc *Blockchain) GetBlocks(from, to int64) []*Block {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if from < 0 {
+		from = 0
+	}
+	if to >= int64(len(bc.Chain)) {
+		to = int64(len(bc.Chain)) - 1
+	}
+	if from > to {
+		return nil
+	}
+	blocks := make([]*Block, to-from+1)
+	copy(blocks, bc.Chain[from:to+1])
+	return blocks
+}
+
 func (bc *Blockchain) GetBalance(address string) int64 {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
 	balance := int64(0)
 	for _, block := range bc.Chain {
 		for _, tx := range block.Transactions {
@@ -65,8 +159,13 @@ func (bc *Blockchain) GetBalance(address string) int64 {
 	return balance
 }
 
-// Add this method to your Blockchain struct
+// DeployContract verifies bytecode and returns the contract's address.
+// Code that fails vm.Verify is rejected.
 func (bc *Blockchain) DeployContract(bytecode []byte) (string, error) {
+	if err := vm.Verify(bytecode); err != nil {
+		return "", fmt.Errorf("invalid contract: %w", err)
+	}
+
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
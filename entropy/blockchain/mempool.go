@@ -0,0 +1,150 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrTxKnown     = errors.New("transaction already known")
+	ErrTxIncluded  = errors.New("transaction already in a block")
+	ErrMempoolFull = errors.New("mempool is full")
+	ErrInvalidTx   = errors.New("invalid transaction")
+)
+
+// DefaultMempoolSize is the number of transactions a mempool holds
+const DefaultMempoolSize = 10000
+
+// Mempool holds valid transactions waiting to be included in a block
+type Mempool struct {
+	chain   *Blockchain
+	maxSize int
+
+	txs   map[string]Transaction
+	order []string // insertion order, for fair block building
+	mu    sync.RWMutex
+}
+
+// NewMempool creates a mempool for chain. Transactions are dropped from the
+// pool as soon as a block including them is added to the chain.
+func NewMempool(chain *Blockchain, maxSize int) *Mempool {
+	mp := &Mempool{
+		chain:   chain,
+		maxSize: maxSize,
+		txs:     make(map[string]Transaction),
+	}
+	chain.OnBlockAdded(mp.removeIncluded)
+	return mp
+}
+
+// CheckTransaction performs the stateless checks every transaction must pass
+func CheckTransaction(tx Transaction) error {
+	if tx.From == "" || tx.To == "" {
+		return fmt.Errorf("%w: missing sender or recipient", ErrInvalidTx)
+	}
+	if tx.From == tx.To {
+		return fmt.Errorf("%w: sender and recipient are the same", ErrInvalidTx)
+	}
+	if tx.Amount <= 0 {
+		return fmt.Errorf("%w: amount must be positive", ErrInvalidTx)
+	}
+	return nil
+}
+
+// Add inserts a transaction after checking it isn't a duplicate
+func (mp *Mempool) Add(tx Transaction) error {
+	if err := CheckTransaction(tx); err != nil {
+		return err
+	}
+	hash := tx.Hash()
+	if mp.chain.HasTransaction(hash) {
+		return ErrTxIncluded
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if _, exists := mp.txs[hash]; exists {
+		return ErrTxKnown
+	}
+	if len(mp.txs) >= mp.maxSize {
+		return ErrMempoolFull
+	}
+	mp.txs[hash] = tx
+	mp.order = append(mp.order, hash)
+	return nil
+}
+
+// Has reports whether the mempool holds the transaction with hash
+func (mp *Mempool) Has(hash string) bool {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	_, exists := mp.txs[hash]
+	return exists
+}
+
+// Get returns the transaction with hash, if the mempool holds it
+func (mp *Mempool) Get(hash string) (Transaction, bool) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	tx, exists := mp.txs[hash]
+	return tx, exists
+}
+
+// Pending returns up to n transactions in the order they arrived
+func (mp *Mempool) Pending(n int) []Transaction {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	if n > len(mp.order) {
+		n = len(mp.order)
+	}
+	txs := make([]Transaction, 0, n)
+	for _, hash := range mp.order[:n] {
+		txs = append(txs, mp.txs[hash])
+	}
+	return txs
+}
+
+// Size returns the number of transactions in the mempool
+func (mp *Mempool) Size() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return len(mp.txs)
+}
+
+// Remove drops the given transactions from the mempool
+func (mp *Mempool) Remove(hashes ...string) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	removed := false
+	for _, hash := range hashes {
+		if _, exists := mp.txs[hash]; exists {
+			delete(mp.txs, hash)
+			removed = true
+		}
+	}
+	if !removed {
+		return
+	}
+	order := mp.order[:0]
+	for _, hash := range mp.order {
+		if _, exists := mp.txs[hash]; exists {
+			order = append(order, hash)
+		}
+	}
+	mp.order = order
+}
+
+func (mp *Mempool) removeIncluded(block *Block) {
+	hashes := make([]string, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		hashes[i] = tx.Hash()
+	}
+	mp.Remove(hashes...)
+}
@@ -0,0 +1,9 @@
+package consensus
+
+// Vote is a validator's attestation for a block at a given height
+type Vote struct {
+    Height    int64
+    BlockHash string
+    Validator string
+    Approve   bool
+}
@@ -0,0 +1,184 @@
+package network
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/control"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/multiformats/go-multiaddr"
+)
+
+// banListFile is the name of the ban list inside the data directory
+const banListFile = "banlist.json"
+
+// Ban records why and until when a peer is refused. A zero Until means the
+// ban is persistent.
+type Ban struct {
+    Until  time.Time `json:"until,omitempty"`
+    Reason string    `json:"reason"`
+    // Count is the number of times the peer has been banned
+    Count int `json:"count"`
+}
+
+// Active reports whether the ban is still in force at now
+func (b *Ban) Active(now time.Time) bool {
+    return b.Until.IsZero() || now.Before(b.Until)
+}
+
+// BanList is the set of banned peers, saved to disk on every change so
+// bans survive restarts
+type BanList struct {
+    path string
+    bans map[peer.ID]*Ban
+    mu   sync.RWMutex
+}
+
+// LoadBanList reads the ban list stored in dataDir, starting empty if the
+// file doesn't exist yet. An empty dataDir gives a list that isn't saved.
+func LoadBanList(dataDir string) (*BanList, error) {
+    if dataDir == "" {
+        return &BanList{bans: make(map[peer.ID]*Ban)}, nil
+    }
+    bl := &BanList{
+        path: filepath.Join(dataDir, banListFile),
+        bans: make(map[peer.ID]*Ban),
+    }
+
+    data, err := os.ReadFile(bl.path)
+    if errors.Is(err, os.ErrNotExist) {
+        return bl, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read ban list: %w", err)
+    }
+
+    var stored map[string]*Ban
+    if err := json.Unmarshal(data, &stored); err != nil {
+        return nil, fmt.Errorf("failed to parse ban list %s: %w", bl.path, err)
+    }
+    for s, ban := range stored {
+        id, err := peer.Decode(s)
+        if err != nil {
+            return nil, fmt.Errorf("invalid peer ID %q in ban list: %w", s, err)
+        }
+        bl.bans[id] = ban
+    }
+    return bl, nil
+}
+
+// IsBanned reports whether id is currently banned
+func (bl *BanList) IsBanned(id peer.ID) bool {
+    bl.mu.RLock()
+    defer bl.mu.RUnlock()
+
+    ban, exists := bl.bans[id]
+    return exists && ban.Active(time.Now())
+}
+
+// count returns how many times id has been banned
+func (bl *BanList) count(id peer.ID) int {
+    bl.mu.RLock()
+    defer bl.mu.RUnlock()
+
+    if ban, exists := bl.bans[id]; exists {
+        return ban.Count
+    }
+    return 0
+}
+
+// Ban bans id for duration, or persistently if duration is zero, and
+// returns the updated entry
+func (bl *BanList) Ban(id peer.ID, duration time.Duration, reason string) (Ban, error) {
+    bl.mu.Lock()
+    defer bl.mu.Unlock()
+
+    ban, exists := bl.bans[id]
+    if !exists {
+        ban = &Ban{}
+        bl.bans[id] = ban
+    }
+    ban.Count++
+    ban.Reason = reason
+    if duration == 0 {
+        ban.Until = time.Time{}
+    } else {
+        ban.Until = time.Now().Add(duration)
+    }
+    return *ban, bl.save()
+}
+
+// Unban lifts any ban on id but keeps no history of it
+func (bl *BanList) Unban(id peer.ID) error {
+    bl.mu.Lock()
+    defer bl.mu.Unlock()
+
+    delete(bl.bans, id)
+    return bl.save()
+}
+
+// save writes the list atomically; the caller must hold mu
+func (bl *BanList) save() error {
+    if bl.path == "" {
+        return nil
+    }
+    stored := make(map[string]*Ban, len(bl.bans))
+    for id, ban := range bl.bans {
+        stored[id.String()] = ban
+    }
+    data, err := json.MarshalIndent(stored, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    if err := os.MkdirAll(filepath.Dir(bl.path), 0o700); err != nil {
+        return err
+    }
+    tmp := bl.path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0o600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, bl.path)
+}
+
+// connectionGater refuses connections to and from banned peers and, when
+// an allowlist is configured, from peers not on it
+type connectionGater struct {
+    bans    *BanList
+    allowed map[peer.ID]struct{}
+}
+
+func (g *connectionGater) permitted(id peer.ID) bool {
+    if g.allowed != nil {
+        if _, ok := g.allowed[id]; !ok {
+            return false
+        }
+    }
+    return !g.bans.IsBanned(id)
+}
+
+func (g *connectionGater) InterceptPeerDial(id peer.ID) bool {
+    return g.permitted(id)
+}
+
+func (g *connectionGater) InterceptAddrDial(id peer.ID, _ multiaddr.Multiaddr) bool {
+    return g.permitted(id)
+}
+
+func (g *connectionGater) InterceptAccept(network.ConnMultiaddrs) bool {
+    return true
+}
+
+func (g *connectionGater) InterceptSecured(_ network.Direction, id peer.ID, _ network.ConnMultiaddrs) bool {
+    return g.permitted(id)
+}
+
+func (g *connectionGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+    return true, 0
+}
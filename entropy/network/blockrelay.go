@@ -0,0 +1,294 @@
+package network
+
+import (
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "sync"
+
+    "github.com/bonniegachiengu/sustena_platforms/entropy/blockchain"
+    lru "github.com/hashicorp/golang-lru/v2"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// BlockTxsProtocol is the request/response protocol used to fetch the
+// transactions of a compact block that aren't in the local mempool
+const BlockTxsProtocol = protocol.ID("/sustena/blocktxs/1.0.0")
+
+const (
+    // recentBlocksSize is the number of relayed blocks kept to answer
+    // transaction requests for blocks not yet on the chain
+    recentBlocksSize = 64
+    // seenBlocksSize is the number of block hashes remembered
+    seenBlocksSize = 1024
+)
+
+// BlockHandler applies a block received from a peer. The relay forwards a
+// block only once the handler has added it to the chain.
+type BlockHandler func(from peer.ID, block *blockchain.Block) error
+
+// ShortTxID is the 48-bit ID of a transaction within a compact block. It
+// is keyed on the block hash so colliding transactions can't be crafted
+// once and replayed against every block.
+func ShortTxID(blockHash, txHash string) uint64 {
+    sum := sha256.Sum256([]byte(blockHash + txHash))
+    var buf [8]byte
+    copy(buf[2:], sum[:6])
+    return binary.BigEndian.Uint64(buf[:])
+}
+
+// NewCompactBlock builds the compact form of block
+func NewCompactBlock(block *blockchain.Block) *CompactBlockMessage {
+    ids := make([]uint64, len(block.Transactions))
+    for i, tx := range block.Transactions {
+        ids[i] = ShortTxID(block.Hash, tx.Hash())
+    }
+    return &CompactBlockMessage{Header: block.Header(), ShortIDs: ids}
+}
+
+// BlockRelay propagates blocks as compact blocks. Receivers rebuild the
+// block from their mempool, request only the transactions they lack from
+// the relaying peer, and fall back to downloading the full block when the
+// rebuilt block doesn't match its header. Full BlockMessages are still
+// accepted and delivered to subscribers unchanged.
+type BlockRelay struct {
+    p2p     *P2PNetwork
+    mempool *blockchain.Mempool
+    handle  BlockHandler
+
+    // recent holds relayed blocks by hash so peers can fetch their
+    // transactions before or after we apply them
+    recent *lru.Cache[string, *blockchain.Block]
+    // seen holds every block hash processed, valid or not
+    seen *lru.Cache[string, struct{}]
+
+    mu       sync.Mutex
+    inflight map[string]struct{}
+}
+
+func NewBlockRelay(p2p *P2PNetwork, mempool *blockchain.Mempool, handle BlockHandler) *BlockRelay {
+    recent, _ := lru.New[string, *blockchain.Block](recentBlocksSize)
+    seen, _ := lru.New[string, struct{}](seenBlocksSize)
+    r := &BlockRelay{
+        p2p:      p2p,
+        mempool:  mempool,
+        handle:   handle,
+        recent:   recent,
+        seen:     seen,
+        inflight: make(map[string]struct{}),
+    }
+    p2p.serve(BlockTxsProtocol, r.handleRequest)
+    p2p.SetGossipHook(TopicBlocks, r.handleGossip)
+    return r
+}
+
+// Start subscribes to the block topic so compact blocks are received.
+// Full blocks are delivered to every subscriber; this one discards them.
+func (r *BlockRelay) Start() error {
+    sub, err := r.p2p.Subscribe(TopicBlocks)
+    if err != nil {
+        return err
+    }
+    go func() {
+        for range sub {
+        }
+    }()
+    return nil
+}
+
+// PublishBlock announces a block, normally one produced locally, as a
+// compact block
+func (r *BlockRelay) PublishBlock(block *blockchain.Block) error {
+    r.seen.Add(block.Hash, struct{}{})
+    r.recent.Add(block.Hash, block)
+    return r.p2p.Publish(NewCompactBlock(block))
+}
+
+// handleGossip consumes compact blocks, which are rebuilt in the
+// background and re-published once applied, and lets full blocks through
+func (r *BlockRelay) handleGossip(from peer.ID, msg Payload) bool {
+    m, ok := msg.(*CompactBlockMessage)
+    if !ok {
+        return true
+    }
+    hash := m.Header.Hash
+    if r.seen.Contains(hash) || r.onChain(m.Header) {
+        return false
+    }
+
+    r.mu.Lock()
+    _, fetching := r.inflight[hash]
+    if !fetching {
+        r.inflight[hash] = struct{}{}
+    }
+    r.mu.Unlock()
+
+    if !fetching {
+        go r.receive(from, m)
+    }
+    return false
+}
+
+// onChain reports whether the block described by h is already applied
+func (r *BlockRelay) onChain(h blockchain.BlockHeader) bool {
+    block := r.p2p.chain.GetBlock(h.Index)
+    return block != nil && block.Hash == h.Hash
+}
+
+// receive rebuilds a compact block, hands it to the block handler and
+// relays it onwards if it was applied
+func (r *BlockRelay) receive(from peer.ID, m *CompactBlockMessage) {
+    hash := m.Header.Hash
+    defer func() {
+        r.mu.Lock()
+        delete(r.inflight, hash)
+        r.mu.Unlock()
+    }()
+
+    block, err := r.reconstruct(from, m)
+    if err != nil {
+        if errors.Is(err, errInvalidData) {
+            r.p2p.ReportPeer(from, PenaltyInvalidBlock, err.Error())
+        } else {
+            r.p2p.ReportPeer(from, PenaltyFailedRequest, err.Error())
+        }
+        return
+    }
+    r.seen.Add(hash, struct{}{})
+
+    if err := r.handle(from, block); err != nil {
+        r.p2p.logger.Printf("Dropping block %d from %s: %v", block.Index, from, err)
+        return
+    }
+    if !r.onChain(m.Header) {
+        // The handler deferred the block, e.g. to sync its ancestors first
+        return
+    }
+    r.recent.Add(hash, block)
+    if err := r.p2p.Publish(m); err != nil {
+        r.p2p.logger.Printf("Failed to relay block %d: %v", block.Index, err)
+    }
+}
+
+// reconstruct rebuilds the block described by m from the mempool and the
+// relaying peer
+func (r *BlockRelay) reconstruct(from peer.ID, m *CompactBlockMessage) (*blockchain.Block, error) {
+    h := m.Header
+
+    // Map the short IDs of the mempool onto this block. IDs shared by more
+    // than one pooled transaction are ambiguous and fetched instead.
+    pool := make(map[uint64]blockchain.Transaction)
+    ambiguous := make(map[uint64]bool)
+    for _, tx := range r.mempool.Pending(r.mempool.Size()) {
+        id := ShortTxID(h.Hash, tx.Hash())
+        if _, dup := pool[id]; dup {
+            ambiguous[id] = true
+        }
+        pool[id] = tx
+    }
+
+    txs := make([]blockchain.Transaction, len(m.ShortIDs))
+    var missing []uint64
+    for i, id := range m.ShortIDs {
+        tx, ok := pool[id]
+        if !ok || ambiguous[id] {
+            missing = append(missing, uint64(i))
+            continue
+        }
+        txs[i] = tx
+    }
+
+    if len(missing) > 0 {
+        fetched, err := r.fetchTransactions(from, h, m.ShortIDs, missing)
+        if err != nil {
+            return nil, err
+        }
+        for i, idx := range missing {
+            txs[idx] = fetched[i]
+        }
+    }
+
+    block := blockchain.BlockFromHeader(h, txs)
+    if block.CalculateHash() == h.Hash {
+        return block, nil
+    }
+    // A short ID collided with an unrelated pooled transaction, or the peer
+    // relayed a header that doesn't match its transactions
+    return r.fetchBlock(from, h)
+}
+
+// fetchTransactions requests the transactions at the missing positions and
+// checks each one against its short ID
+func (r *BlockRelay) fetchTransactions(from peer.ID, h blockchain.BlockHeader, ids, missing []uint64) ([]blockchain.Transaction, error) {
+    resp, err := r.p2p.request(from, BlockTxsProtocol, &GetBlockTxsMessage{
+        Height:  h.Index,
+        Hash:    h.Hash,
+        Indexes: missing,
+    })
+    if err != nil {
+        return nil, fmt.Errorf("block transaction request failed: %w", err)
+    }
+    msg, ok := resp.(*BlockTxsMessage)
+    if !ok {
+        return nil, fmt.Errorf("%w: unexpected %s response to block transaction request", errInvalidData, resp.Type())
+    }
+    if msg.Hash != h.Hash || len(msg.Transactions) != len(missing) {
+        return nil, fmt.Errorf("%w: expected %d transactions of block %d, got %d", errInvalidData, len(missing), h.Index, len(msg.Transactions))
+    }
+    for i, tx := range msg.Transactions {
+        if ShortTxID(h.Hash, tx.Hash()) != ids[missing[i]] {
+            return nil, fmt.Errorf("%w: transaction %d of block %d does not match its short ID", errInvalidData, missing[i], h.Index)
+        }
+    }
+    return msg.Transactions, nil
+}
+
+// fetchBlock downloads the full block over the sync protocol
+func (r *BlockRelay) fetchBlock(from peer.ID, h blockchain.BlockHeader) (*blockchain.Block, error) {
+    resp, err := r.p2p.request(from, SyncProtocol, &SyncRequestMessage{
+        FromHeight: h.Index,
+        ToHeight:   h.Index,
+    })
+    if err != nil {
+        return nil, fmt.Errorf("block request failed: %w", err)
+    }
+    msg, ok := resp.(*BlocksMessage)
+    if !ok {
+        return nil, fmt.Errorf("%w: unexpected %s response to block request", errInvalidData, resp.Type())
+    }
+    if len(msg.Blocks) != 1 {
+        return nil, fmt.Errorf("%w: expected block %d, got %d blocks", errInvalidData, h.Index, len(msg.Blocks))
+    }
+    block := msg.Blocks[0]
+    if block.Header() != h || block.CalculateHash() != h.Hash {
+        return nil, fmt.Errorf("%w: block %d does not match its header", errInvalidData, h.Index)
+    }
+    return block, nil
+}
+
+// handleRequest serves transactions of relayed or applied blocks
+func (r *BlockRelay) handleRequest(from peer.ID, req Payload) (Payload, error) {
+    msg, ok := req.(*GetBlockTxsMessage)
+    if !ok {
+        return nil, fmt.Errorf("unexpected %s request", req.Type())
+    }
+
+    block, ok := r.recent.Get(msg.Hash)
+    if !ok {
+        block = r.p2p.chain.GetBlock(msg.Height)
+        if block == nil || block.Hash != msg.Hash {
+            return nil, fmt.Errorf("unknown block %s", msg.Hash)
+        }
+    }
+
+    resp := &BlockTxsMessage{Hash: block.Hash}
+    for _, i := range msg.Indexes {
+        if i >= uint64(len(block.Transactions)) {
+            return nil, fmt.Errorf("block %d has no transaction %d", block.Index, i)
+        }
+        resp.Transactions = append(resp.Transactions, block.Transactions[i])
+    }
+    return resp, nil
+}
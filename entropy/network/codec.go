@@ -0,0 +1,326 @@
+package network
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+)
+
+// WireVersion is the version of the envelope format written by this node
+const WireVersion uint8 = 1
+
+// maxFieldSize bounds length-prefixed fields so a corrupt prefix can't
+// trigger a huge allocation
+const maxFieldSize = 16 << 20
+
+var (
+    ErrUnsupportedVersion = errors.New("unsupported wire version")
+    ErrUnknownMessageType = errors.New("unknown message type")
+    ErrTruncated          = errors.New("truncated message")
+)
+
+// MessageType identifies the payload carried by an Envelope
+type MessageType uint8
+
+const (
+    MsgBlock MessageType = iota + 1
+    MsgTransaction
+    MsgVote
+    MsgStatus
+    MsgSyncRequest
+    MsgHeaders
+    MsgBlocks
+    MsgHandshake
+    MsgTxAnnounce
+    MsgGetTxs
+    MsgTransactions
+    MsgCompactBlock
+    MsgGetBlockTxs
+    MsgBlockTxs
+)
+
+func (t MessageType) String() string {
+    switch t {
+    case MsgBlock:
+        return "block"
+    case MsgTransaction:
+        return "transaction"
+    case MsgVote:
+        return "vote"
+    case MsgStatus:
+        return "status"
+    case MsgSyncRequest:
+        return "sync_request"
+    case MsgHeaders:
+        return "headers"
+    case MsgBlocks:
+        return "blocks"
+    case MsgHandshake:
+        return "handshake"
+    case MsgTxAnnounce:
+        return "tx_announce"
+    case MsgGetTxs:
+        return "get_txs"
+    case MsgTransactions:
+        return "transactions"
+    case MsgCompactBlock:
+        return "compact_block"
+    case MsgGetBlockTxs:
+        return "get_block_txs"
+    case MsgBlockTxs:
+        return "block_txs"
+    default:
+        return fmt.Sprintf("unknown(%d)", uint8(t))
+    }
+}
+
+// Envelope is the outer frame of every message sent between nodes.
+//
+// Layout: version (1 byte) | type (1 byte) | chain ID (string) | payload (bytes),
+// where strings and byte slices are prefixed with their uvarint length.
+type Envelope struct {
+    Version uint8
+    Type    MessageType
+    ChainID string
+    Payload []byte
+}
+
+// Payload is a typed message that can travel inside an Envelope
+type Payload interface {
+    Type() MessageType
+    encode(e *encoder)
+    decode(d *decoder)
+}
+
+// EncodeMessage wraps msg in an envelope for chainID and serializes it
+func EncodeMessage(chainID string, msg Payload) []byte {
+    var body encoder
+    msg.encode(&body)
+
+    env := Envelope{
+        Version: WireVersion,
+        Type:    msg.Type(),
+        ChainID: chainID,
+        Payload: body.Bytes(),
+    }
+    return env.Marshal()
+}
+
+// DecodeMessage parses an envelope and its typed payload
+func DecodeMessage(data []byte) (*Envelope, Payload, error) {
+    env, err := UnmarshalEnvelope(data)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    msg := newPayload(env.Type)
+    if msg == nil {
+        return env, nil, fmt.Errorf("%w: %d", ErrUnknownMessageType, env.Type)
+    }
+    d := newDecoder(env.Payload)
+    msg.decode(d)
+    if err := d.finish(); err != nil {
+        return env, nil, fmt.Errorf("invalid %s payload: %w", env.Type, err)
+    }
+    return env, msg, nil
+}
+
+func (env *Envelope) Marshal() []byte {
+    var e encoder
+    e.putUint8(env.Version)
+    e.putUint8(uint8(env.Type))
+    e.putString(env.ChainID)
+    e.putBytes(env.Payload)
+    return e.Bytes()
+}
+
+func UnmarshalEnvelope(data []byte) (*Envelope, error) {
+    if len(data) == 0 {
+        return nil, ErrTruncated
+    }
+    if data[0] != WireVersion {
+        return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, data[0])
+    }
+
+    d := newDecoder(data)
+    env := &Envelope{
+        Version: d.uint8(),
+        Type:    MessageType(d.uint8()),
+        ChainID: d.string(),
+        Payload: d.bytes(),
+    }
+    if err := d.finish(); err != nil {
+        return nil, fmt.Errorf("invalid envelope: %w", err)
+    }
+    return env, nil
+}
+
+// encoder appends primitive values in the compact wire format
+type encoder struct {
+    bytes.Buffer
+}
+
+func (e *encoder) putUint8(v uint8) {
+    e.WriteByte(v)
+}
+
+func (e *encoder) putBool(v bool) {
+    if v {
+        e.WriteByte(1)
+    } else {
+        e.WriteByte(0)
+    }
+}
+
+func (e *encoder) putUvarint(v uint64) {
+    var buf [binary.MaxVarintLen64]byte
+    n := binary.PutUvarint(buf[:], v)
+    e.Write(buf[:n])
+}
+
+func (e *encoder) putVarint(v int64) {
+    var buf [binary.MaxVarintLen64]byte
+    n := binary.PutVarint(buf[:], v)
+    e.Write(buf[:n])
+}
+
+// putUint48 writes the low 48 bits of v as 6 big-endian bytes
+func (e *encoder) putUint48(v uint64) {
+    var buf [8]byte
+    binary.BigEndian.PutUint64(buf[:], v)
+    e.Write(buf[2:])
+}
+
+func (e *encoder) putBytes(v []byte) {
+    e.putUvarint(uint64(len(v)))
+    e.Write(v)
+}
+
+func (e *encoder) putString(v string) {
+    e.putUvarint(uint64(len(v)))
+    e.WriteString(v)
+}
+
+// decoder reads primitive values written by encoder. The first error is
+// sticky; subsequent reads return zero values.
+type decoder struct {
+    buf []byte
+    err error
+}
+
+func newDecoder(buf []byte) *decoder {
+    return &decoder{buf: buf}
+}
+
+func (d *decoder) fail(err error) {
+    if d.err == nil {
+        d.err = err
+    }
+}
+
+func (d *decoder) uint8() uint8 {
+    if d.err != nil {
+        return 0
+    }
+    if len(d.buf) < 1 {
+        d.fail(ErrTruncated)
+        return 0
+    }
+    v := d.buf[0]
+    d.buf = d.buf[1:]
+    return v
+}
+
+func (d *decoder) bool() bool {
+    switch d.uint8() {
+    case 0:
+        return false
+    case 1:
+        return true
+    default:
+        d.fail(errors.New("invalid boolean"))
+        return false
+    }
+}
+
+func (d *decoder) uvarint() uint64 {
+    if d.err != nil {
+        return 0
+    }
+    v, n := binary.Uvarint(d.buf)
+    if n <= 0 {
+        d.fail(ErrTruncated)
+        return 0
+    }
+    d.buf = d.buf[n:]
+    return v
+}
+
+func (d *decoder) varint() int64 {
+    if d.err != nil {
+        return 0
+    }
+    v, n := binary.Varint(d.buf)
+    if n <= 0 {
+        d.fail(ErrTruncated)
+        return 0
+    }
+    d.buf = d.buf[n:]
+    return v
+}
+
+func (d *decoder) uint48() uint64 {
+    if d.err != nil {
+        return 0
+    }
+    if len(d.buf) < 6 {
+        d.fail(ErrTruncated)
+        return 0
+    }
+    var buf [8]byte
+    copy(buf[2:], d.buf[:6])
+    d.buf = d.buf[6:]
+    return binary.BigEndian.Uint64(buf[:])
+}
+
+func (d *decoder) bytes() []byte {
+    n := d.uvarint()
+    if d.err != nil {
+        return nil
+    }
+    if n > maxFieldSize || n > uint64(len(d.buf)) {
+        d.fail(ErrTruncated)
+        return nil
+    }
+    v := make([]byte, n)
+    copy(v, d.buf[:n])
+    d.buf = d.buf[n:]
+    return v
+}
+
+func (d *decoder) string() string {
+    return string(d.bytes())
+}
+
+// length reads a collection length, rejecting values that can't possibly
+// fit in the remaining input
+func (d *decoder) length() int {
+    n := d.uvarint()
+    if n > uint64(len(d.buf)) {
+        d.fail(ErrTruncated)
+        return 0
+    }
+    return int(n)
+}
+
+// finish reports the first decoding error, or an error if input remains
+func (d *decoder) finish() error {
+    if d.err != nil {
+        return d.err
+    }
+    if len(d.buf) != 0 {
+        return fmt.Errorf("%d trailing bytes", len(d.buf))
+    }
+    return nil
+}
@@ -0,0 +1,185 @@
+package network
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    dht "github.com/libp2p/go-libp2p-kad-dht"
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+    drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+    dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+    "github.com/multiformats/go-multiaddr"
+)
+
+const (
+    // mdnsServiceName is advertised on the local network
+    mdnsServiceName = "sustena-network"
+    // dhtProtocolPrefix keeps the Sustena DHT separate from the public one
+    dhtProtocolPrefix = "/sustena"
+    // rediscoveryInterval is how often the peer count is topped up
+    rediscoveryInterval = 30 * time.Second
+    // dialTimeout bounds a single discovery dial
+    dialTimeout = 15 * time.Second
+)
+
+// rendezvous is the DHT namespace nodes of this chain advertise under
+func (p *P2PNetwork) rendezvous() string {
+    return "sustena/" + p.chainID
+}
+
+func (p *P2PNetwork) setupDiscovery() error {
+    if p.config.EnableMDNS {
+        // Setup local mDNS discovery
+        s := mdns.NewMdnsService(p.host, mdnsServiceName, &discoveryNotifee{p: p})
+        if err := s.Start(); err != nil {
+            return fmt.Errorf("failed to start mDNS: %w", err)
+        }
+        p.mdns = s
+    }
+
+    bootstrap, err := parseBootstrapPeers(p.config.BootstrapPeers)
+    if err != nil {
+        return err
+    }
+    p.bootstrap = bootstrap
+
+    if p.config.EnableDHT {
+        if err := p.setupDHT(); err != nil {
+            return err
+        }
+    }
+
+    go p.discoveryLoop()
+    return nil
+}
+
+func parseBootstrapPeers(addrs []string) ([]peer.AddrInfo, error) {
+    var infos []peer.AddrInfo
+    for _, addr := range addrs {
+        maddr, err := multiaddr.NewMultiaddr(addr)
+        if err != nil {
+            return nil, fmt.Errorf("invalid bootstrap peer %q: %w", addr, err)
+        }
+        info, err := peer.AddrInfoFromP2pAddr(maddr)
+        if err != nil {
+            return nil, fmt.Errorf("invalid bootstrap peer %q: %w", addr, err)
+        }
+        infos = append(infos, *info)
+    }
+    return infos, nil
+}
+
+func (p *P2PNetwork) setupDHT() error {
+    kdht, err := dht.New(p.ctx, p.host,
+        dht.Mode(dht.ModeAutoServer),
+        dht.ProtocolPrefix(dhtProtocolPrefix),
+        dht.BootstrapPeers(p.bootstrap...),
+    )
+    if err != nil {
+        return fmt.Errorf("failed to create DHT: %w", err)
+    }
+    if err := kdht.Bootstrap(p.ctx); err != nil {
+        kdht.Close()
+        return fmt.Errorf("failed to bootstrap DHT: %w", err)
+    }
+
+    p.dht = kdht
+    p.discovery = drouting.NewRoutingDiscovery(kdht)
+    return nil
+}
+
+// discoveryLoop keeps the node connected to TargetPeers compatible peers,
+// re-dialing bootstrap nodes and searching the DHT whenever it falls short
+func (p *P2PNetwork) discoveryLoop() {
+    advertised := false
+    ticker := time.NewTicker(rediscoveryInterval)
+    defer ticker.Stop()
+
+    for {
+        p.connectBootstrapPeers()
+
+        if p.discovery != nil {
+            // Advertising only succeeds once the routing table has peers
+            if !advertised && p.dht.RoutingTable().Size() > 0 {
+                dutil.Advertise(p.ctx, p.discovery, p.rendezvous())
+                advertised = true
+            }
+            p.findPeers()
+        }
+
+        select {
+        case <-p.ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (p *P2PNetwork) needsPeers() bool {
+    return len(p.compatiblePeers()) < p.config.TargetPeers
+}
+
+func (p *P2PNetwork) connectBootstrapPeers() {
+    for _, info := range p.bootstrap {
+        if p.host.Network().Connectedness(info.ID) == network.Connected {
+            continue
+        }
+        if !p.needsPeers() {
+            return
+        }
+        p.dial(info)
+    }
+}
+
+// findPeers looks up other nodes of this chain in the DHT
+func (p *P2PNetwork) findPeers() {
+    if !p.needsPeers() {
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(p.ctx, rediscoveryInterval)
+    defer cancel()
+
+    found, err := p.discovery.FindPeers(ctx, p.rendezvous())
+    if err != nil {
+        p.logger.Printf("DHT peer search failed: %v", err)
+        return
+    }
+    for info := range found {
+        if info.ID == p.host.ID() || len(info.Addrs) == 0 {
+            continue
+        }
+        if p.host.Network().Connectedness(info.ID) == network.Connected || p.bans.IsBanned(info.ID) {
+            continue
+        }
+        p.dial(info)
+        if !p.needsPeers() {
+            return
+        }
+    }
+}
+
+func (p *P2PNetwork) dial(info peer.AddrInfo) {
+    ctx, cancel := context.WithTimeout(p.ctx, dialTimeout)
+    defer cancel()
+
+    if err := p.host.Connect(ctx, info); err != nil {
+        p.logger.Printf("Failed to connect to peer %s: %v", info.ID, err)
+    }
+}
+
+// discoveryNotifee gets notified when we find a new peer via mDNS discovery
+type discoveryNotifee struct {
+    p *P2PNetwork
+}
+
+func (n *discoveryNotifee) HandlePeerFound(pi peer.AddrInfo) {
+    n.p.logger.Printf("Discovered new peer %s", pi.ID)
+    err := n.p.host.Connect(n.p.ctx, pi)
+    if err != nil {
+        n.p.logger.Printf("Error connecting to peer %s: %s", pi.ID, err)
+    }
+}
@@ -0,0 +1,64 @@
+package network
+
+import (
+    "errors"
+    "fmt"
+    "sync"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+    ErrChainIDMismatch = errors.New("chain ID mismatch")
+    ErrNoHandler       = errors.New("no handler registered")
+)
+
+// Handler processes a decoded message received from a peer
+type Handler func(from peer.ID, msg Payload) error
+
+// Dispatcher decodes incoming envelopes and routes each message type to
+// the handler registered for it
+type Dispatcher struct {
+    chainID  string
+    handlers map[MessageType]Handler
+    mu       sync.RWMutex
+}
+
+func NewDispatcher(chainID string) *Dispatcher {
+    return &Dispatcher{
+        chainID:  chainID,
+        handlers: make(map[MessageType]Handler),
+    }
+}
+
+// Register sets the handler for a message type, replacing any previous one
+func (d *Dispatcher) Register(t MessageType, h Handler) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    d.handlers[t] = h
+}
+
+// Dispatch decodes data and invokes the matching handler. Messages for a
+// different chain are rejected before the handler is called.
+func (d *Dispatcher) Dispatch(from peer.ID, data []byte) error {
+    env, msg, err := DecodeMessage(data)
+    if err != nil {
+        return err
+    }
+    if env.ChainID != d.chainID {
+        return fmt.Errorf("%w: got %q, want %q", ErrChainIDMismatch, env.ChainID, d.chainID)
+    }
+    return d.Deliver(from, msg)
+}
+
+// Deliver invokes the handler for an already decoded message, such as one
+// received through Subscribe
+func (d *Dispatcher) Deliver(from peer.ID, msg Payload) error {
+    d.mu.RLock()
+    h, exists := d.handlers[msg.Type()]
+    d.mu.RUnlock()
+    if !exists {
+        return fmt.Errorf("%w for %s messages", ErrNoHandler, msg.Type())
+    }
+    return h(from, msg)
+}
@@ -0,0 +1,250 @@
+package network
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+    "github.com/multiformats/go-multiaddr"
+)
+
+// HandshakeProtocol is opened by the dialing side of every new connection
+const HandshakeProtocol = protocol.ID("/sustena/handshake/1.0.0")
+
+const handshakeTimeout = 10 * time.Second
+
+// Capabilities advertised during the handshake
+const (
+    CapGossip = "gossip"
+    CapSync   = "sync"
+)
+
+// Capabilities lists what this node supports
+var Capabilities = []string{CapGossip, CapSync}
+
+// PeerStatus is what we know about a peer that completed the handshake
+type PeerStatus struct {
+    ID          peer.ID
+    Handshake   HandshakeMessage
+    ConnectedAt time.Time
+}
+
+// HasCapability reports whether the peer advertised capability c
+func (ps *PeerStatus) HasCapability(c string) bool {
+    for _, have := range ps.Handshake.Capabilities {
+        if have == c {
+            return true
+        }
+    }
+    return false
+}
+
+// PeerListener is notified when a peer completes the handshake and when
+// its last connection closes
+type PeerListener interface {
+    PeerConnected(id peer.ID, status *PeerStatus)
+    PeerDisconnected(id peer.ID)
+}
+
+// AddPeerListener registers l for peer lifecycle events
+func (p *P2PNetwork) AddPeerListener(l PeerListener) {
+    p.peersMu.Lock()
+    defer p.peersMu.Unlock()
+    p.listeners = append(p.listeners, l)
+}
+
+func (p *P2PNetwork) setupHandshake() {
+    p.host.SetStreamHandler(HandshakeProtocol, p.handleHandshake)
+    p.host.Network().Notify(&network.NotifyBundle{
+        ConnectedF: func(_ network.Network, conn network.Conn) {
+            if conn.Stat().Direction == network.DirOutbound {
+                go p.initiateHandshake(conn.RemotePeer())
+            } else {
+                go p.awaitHandshake(conn.RemotePeer())
+            }
+        },
+        DisconnectedF: func(n network.Network, conn network.Conn) {
+            id := conn.RemotePeer()
+            if n.Connectedness(id) != network.Connected {
+                p.removePeer(id)
+            }
+        },
+    })
+}
+
+// localHandshake describes this node to peers
+func (p *P2PNetwork) localHandshake() *HandshakeMessage {
+    head := p.chain.GetLastBlock()
+    return &HandshakeMessage{
+        ProtocolVersion: ProtocolVersion,
+        ChainID:         p.chainID,
+        GenesisHash:     p.chain.GenesisHash(),
+        HeadHeight:      head.Index,
+        HeadHash:        head.Hash,
+        Capabilities:    Capabilities,
+    }
+}
+
+// checkHandshake returns the reason a peer is incompatible, or nil
+func (p *P2PNetwork) checkHandshake(remote *HandshakeMessage) error {
+    local := p.localHandshake()
+    if remote.ProtocolVersion != local.ProtocolVersion {
+        return fmt.Errorf("protocol version %d, want %d", remote.ProtocolVersion, local.ProtocolVersion)
+    }
+    if remote.ChainID != local.ChainID {
+        return fmt.Errorf("chain ID %q, want %q", remote.ChainID, local.ChainID)
+    }
+    if remote.GenesisHash != local.GenesisHash {
+        return fmt.Errorf("genesis hash %s, want %s", remote.GenesisHash, local.GenesisHash)
+    }
+    return nil
+}
+
+func (p *P2PNetwork) initiateHandshake(id peer.ID) {
+    if p.peerStatus(id) != nil {
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(p.ctx, handshakeTimeout)
+    defer cancel()
+
+    s, err := p.host.NewStream(ctx, id, HandshakeProtocol)
+    if err != nil {
+        p.rejectPeer(id, fmt.Errorf("handshake not supported: %w", err))
+        return
+    }
+    defer s.Close()
+    s.SetDeadline(time.Now().Add(handshakeTimeout))
+
+    if err := writeFrame(s, p.chainID, p.localHandshake()); err != nil {
+        s.Reset()
+        p.rejectPeer(id, fmt.Errorf("sending handshake: %w", err))
+        return
+    }
+    remote, err := readHandshake(bufio.NewReader(s))
+    if err != nil {
+        s.Reset()
+        p.rejectPeer(id, err)
+        return
+    }
+    p.completeHandshake(id, remote, s.Conn().RemoteMultiaddr())
+}
+
+// awaitHandshake drops inbound peers that never open a handshake
+func (p *P2PNetwork) awaitHandshake(id peer.ID) {
+    select {
+    case <-time.After(2 * handshakeTimeout):
+    case <-p.ctx.Done():
+        return
+    }
+    if p.peerStatus(id) == nil && p.host.Network().Connectedness(id) == network.Connected {
+        p.rejectPeer(id, fmt.Errorf("no handshake within %s", 2*handshakeTimeout))
+    }
+}
+
+func (p *P2PNetwork) handleHandshake(s network.Stream) {
+    defer s.Close()
+    s.SetDeadline(time.Now().Add(handshakeTimeout))
+
+    id := s.Conn().RemotePeer()
+    remote, err := readHandshake(bufio.NewReader(s))
+    if err != nil {
+        s.Reset()
+        p.rejectPeer(id, err)
+        return
+    }
+    // Reply before judging so the dialer learns our side as well
+    if err := writeFrame(s, p.chainID, p.localHandshake()); err != nil {
+        s.Reset()
+        p.rejectPeer(id, fmt.Errorf("sending handshake: %w", err))
+        return
+    }
+    p.completeHandshake(id, remote, s.Conn().RemoteMultiaddr())
+}
+
+func readHandshake(r *bufio.Reader) (*HandshakeMessage, error) {
+    _, msg, err := readEnvelope(r)
+    if err != nil {
+        return nil, fmt.Errorf("reading handshake: %w", err)
+    }
+    hs, ok := msg.(*HandshakeMessage)
+    if !ok {
+        return nil, fmt.Errorf("expected handshake, got %s", msg.Type())
+    }
+    return hs, nil
+}
+
+func (p *P2PNetwork) completeHandshake(id peer.ID, remote *HandshakeMessage, addr multiaddr.Multiaddr) {
+    if err := p.checkHandshake(remote); err != nil {
+        p.rejectPeer(id, err)
+        return
+    }
+
+    status := &PeerStatus{
+        ID:          id,
+        Handshake:   *remote,
+        ConnectedAt: time.Now(),
+    }
+    p.peersMu.Lock()
+    _, known := p.peers[id]
+    p.peers[id] = status
+    listeners := append([]PeerListener(nil), p.listeners...)
+    p.peersMu.Unlock()
+
+    if !known {
+        p.logger.Printf("Peer %s at %s joined (height %d)", id, addr, remote.HeadHeight)
+    }
+    for _, l := range listeners {
+        l.PeerConnected(id, status)
+    }
+}
+
+// rejectPeer disconnects an incompatible peer and logs why
+func (p *P2PNetwork) rejectPeer(id peer.ID, reason error) {
+    if p.ctx.Err() != nil {
+        return
+    }
+    p.logger.Printf("Disconnecting peer %s: %v", id, reason)
+    p.removePeer(id)
+    p.host.Network().ClosePeer(id)
+}
+
+func (p *P2PNetwork) removePeer(id peer.ID) {
+    p.peersMu.Lock()
+    _, known := p.peers[id]
+    delete(p.peers, id)
+    listeners := append([]PeerListener(nil), p.listeners...)
+    p.peersMu.Unlock()
+
+    p.metrics.forget(id)
+    p.scorer.disconnected(id)
+    if known {
+        for _, l := range listeners {
+            l.PeerDisconnected(id)
+        }
+    }
+}
+
+// peerStatus returns the handshake state of a peer, or nil if it hasn't
+// completed the handshake
+func (p *P2PNetwork) peerStatus(id peer.ID) *PeerStatus {
+    p.peersMu.RLock()
+    defer p.peersMu.RUnlock()
+    return p.peers[id]
+}
+
+// compatiblePeers returns the peers that completed the handshake
+func (p *P2PNetwork) compatiblePeers() []peer.ID {
+    p.peersMu.RLock()
+    defer p.peersMu.RUnlock()
+
+    ids := make([]peer.ID, 0, len(p.peers))
+    for id := range p.peers {
+        ids = append(ids, id)
+    }
+    return ids
+}
@@ -0,0 +1,98 @@
+package network
+
+import (
+    "crypto/rand"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+
+    "github.com/libp2p/go-libp2p/core/crypto"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/multiformats/go-multiaddr"
+    manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// identityFile holds the node's libp2p private key inside the data directory
+const identityFile = "node.key"
+
+// LoadIdentity returns the node's private key, generating and saving an
+// Ed25519 key on first start. The key file is only readable by its owner.
+func LoadIdentity(dataDir string) (crypto.PrivKey, error) {
+    path := filepath.Join(dataDir, identityFile)
+
+    data, err := os.ReadFile(path)
+    if err == nil {
+        if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0o077 != 0 {
+            if err := os.Chmod(path, 0o600); err != nil {
+                return nil, fmt.Errorf("identity key %s is readable by others: %w", path, err)
+            }
+        }
+        key, err := crypto.UnmarshalPrivateKey(data)
+        if err != nil {
+            return nil, fmt.Errorf("failed to parse identity key %s: %w", path, err)
+        }
+        return key, nil
+    }
+    if !errors.Is(err, os.ErrNotExist) {
+        return nil, fmt.Errorf("failed to read identity key: %w", err)
+    }
+
+    key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+    if err != nil {
+        return nil, fmt.Errorf("failed to generate identity key: %w", err)
+    }
+    data, err = crypto.MarshalPrivateKey(key)
+    if err != nil {
+        return nil, err
+    }
+    if err := os.MkdirAll(dataDir, 0o700); err != nil {
+        return nil, fmt.Errorf("failed to create data directory: %w", err)
+    }
+    // O_EXCL so two processes starting at once can't overwrite each other's key
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create identity key: %w", err)
+    }
+    if _, err := f.Write(data); err != nil {
+        f.Close()
+        return nil, fmt.Errorf("failed to write identity key: %w", err)
+    }
+    if err := f.Close(); err != nil {
+        return nil, err
+    }
+    return key, nil
+}
+
+// NodeAddrs returns the multiaddrs other nodes can use to reach a node with
+// the given ID listening on listenAddr, including the /p2p component.
+// Unspecified listen addresses are expanded to the local interfaces.
+func NodeAddrs(listenAddr string, id peer.ID) ([]multiaddr.Multiaddr, error) {
+    listen, err := multiaddr.NewMultiaddr(listenAddr)
+    if err != nil {
+        return nil, fmt.Errorf("invalid listen address %q: %w", listenAddr, err)
+    }
+    ifaces, err := manet.InterfaceMultiaddrs()
+    if err != nil {
+        return nil, err
+    }
+    resolved, err := manet.ResolveUnspecifiedAddresses([]multiaddr.Multiaddr{listen}, ifaces)
+    if err != nil {
+        return nil, err
+    }
+    return p2pAddrs(resolved, id)
+}
+
+// Addrs returns this node's full multiaddrs for sharing with other nodes
+func (p *P2PNetwork) Addrs() ([]multiaddr.Multiaddr, error) {
+    return p2pAddrs(p.host.Addrs(), p.host.ID())
+}
+
+// ID returns this node's peer ID
+func (p *P2PNetwork) ID() peer.ID {
+    return p.host.ID()
+}
+
+func p2pAddrs(addrs []multiaddr.Multiaddr, id peer.ID) ([]multiaddr.Multiaddr, error) {
+    return peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: id, Addrs: addrs})
+}
@@ -0,0 +1,413 @@
+package network
+
+import (
+    "github.com/bonniegachiengu/sustena_platforms/entropy/blockchain"
+    "github.com/bonniegachiengu/sustena_platforms/entropy/consensus"
+)
+
+// BlockMessage announces a new block
+type BlockMessage struct {
+    Block *blockchain.Block
+}
+
+// TransactionMessage carries a single transaction
+type TransactionMessage struct {
+    Transaction blockchain.Transaction
+}
+
+// VoteMessage carries a validator's vote on a block
+type VoteMessage struct {
+    Vote consensus.Vote
+}
+
+// StatusMessage describes the sender's view of the chain
+type StatusMessage struct {
+    ProtocolVersion uint32
+    GenesisHash     string
+    HeadHeight      int64
+    HeadHash        string
+}
+
+// SyncRequestMessage asks a peer for blocks in [FromHeight, ToHeight]
+type SyncRequestMessage struct {
+    FromHeight  int64
+    ToHeight    int64
+    HeadersOnly bool
+}
+
+// HeadersMessage answers a headers-only SyncRequestMessage
+type HeadersMessage struct {
+    Headers []blockchain.BlockHeader
+}
+
+// BlocksMessage answers a SyncRequestMessage with full blocks
+type BlocksMessage struct {
+    Blocks []*blockchain.Block
+}
+
+// HandshakeMessage is exchanged when two nodes connect
+type HandshakeMessage struct {
+    ProtocolVersion uint32
+    ChainID         string
+    GenesisHash     string
+    HeadHeight      int64
+    HeadHash        string
+    Capabilities    []string
+}
+
+// TxAnnounceMessage advertises transaction hashes the sender can serve
+type TxAnnounceMessage struct {
+    Hashes []string
+}
+
+// GetTxsMessage requests transaction bodies by hash
+type GetTxsMessage struct {
+    Hashes []string
+}
+
+// TransactionsMessage answers a GetTxsMessage with the bodies the sender has
+type TransactionsMessage struct {
+    Transactions []blockchain.Transaction
+}
+
+// CompactBlockMessage announces a block as its header plus a short ID for
+// each transaction, to be rebuilt from the receiver's mempool
+type CompactBlockMessage struct {
+    Header   blockchain.BlockHeader
+    ShortIDs []uint64
+}
+
+// GetBlockTxsMessage requests the transactions at the given positions of a
+// block that couldn't be rebuilt from the mempool
+type GetBlockTxsMessage struct {
+    Height  int64
+    Hash    string
+    Indexes []uint64
+}
+
+// BlockTxsMessage answers a GetBlockTxsMessage, in the order requested
+type BlockTxsMessage struct {
+    Hash         string
+    Transactions []blockchain.Transaction
+}
+
+func newPayload(t MessageType) Payload {
+    switch t {
+    case MsgBlock:
+        return &BlockMessage{}
+    case MsgTransaction:
+        return &TransactionMessage{}
+    case MsgVote:
+        return &VoteMessage{}
+    case MsgStatus:
+        return &StatusMessage{}
+    case MsgSyncRequest:
+        return &SyncRequestMessage{}
+    case MsgHeaders:
+        return &HeadersMessage{}
+    case MsgBlocks:
+        return &BlocksMessage{}
+    case MsgHandshake:
+        return &HandshakeMessage{}
+    case MsgTxAnnounce:
+        return &TxAnnounceMessage{}
+    case MsgGetTxs:
+        return &GetTxsMessage{}
+    case MsgTransactions:
+        return &TransactionsMessage{}
+    case MsgCompactBlock:
+        return &CompactBlockMessage{}
+    case MsgGetBlockTxs:
+        return &GetBlockTxsMessage{}
+    case MsgBlockTxs:
+        return &BlockTxsMessage{}
+    default:
+        return nil
+    }
+}
+
+func (m *BlockMessage) Type() MessageType { return MsgBlock }
+
+func (m *BlockMessage) encode(e *encoder) {
+    encodeBlock(e, m.Block)
+}
+
+func (m *BlockMessage) decode(d *decoder) {
+    m.Block = decodeBlock(d)
+}
+
+func (m *TransactionMessage) Type() MessageType { return MsgTransaction }
+
+func (m *TransactionMessage) encode(e *encoder) {
+    encodeTransaction(e, m.Transaction)
+}
+
+func (m *TransactionMessage) decode(d *decoder) {
+    m.Transaction = decodeTransaction(d)
+}
+
+func (m *VoteMessage) Type() MessageType { return MsgVote }
+
+func (m *VoteMessage) encode(e *encoder) {
+    e.putVarint(m.Vote.Height)
+    e.putString(m.Vote.BlockHash)
+    e.putString(m.Vote.Validator)
+    e.putBool(m.Vote.Approve)
+}
+
+func (m *VoteMessage) decode(d *decoder) {
+    m.Vote.Height = d.varint()
+    m.Vote.BlockHash = d.string()
+    m.Vote.Validator = d.string()
+    m.Vote.Approve = d.bool()
+}
+
+func (m *StatusMessage) Type() MessageType { return MsgStatus }
+
+func (m *StatusMessage) encode(e *encoder) {
+    e.putUvarint(uint64(m.ProtocolVersion))
+    e.putString(m.GenesisHash)
+    e.putVarint(m.HeadHeight)
+    e.putString(m.HeadHash)
+}
+
+func (m *StatusMessage) decode(d *decoder) {
+    m.ProtocolVersion = uint32(d.uvarint())
+    m.GenesisHash = d.string()
+    m.HeadHeight = d.varint()
+    m.HeadHash = d.string()
+}
+
+func (m *SyncRequestMessage) Type() MessageType { return MsgSyncRequest }
+
+func (m *SyncRequestMessage) encode(e *encoder) {
+    e.putVarint(m.FromHeight)
+    e.putVarint(m.ToHeight)
+    e.putBool(m.HeadersOnly)
+}
+
+func (m *SyncRequestMessage) decode(d *decoder) {
+    m.FromHeight = d.varint()
+    m.ToHeight = d.varint()
+    m.HeadersOnly = d.bool()
+}
+
+func (m *HeadersMessage) Type() MessageType { return MsgHeaders }
+
+func (m *HeadersMessage) encode(e *encoder) {
+    e.putUvarint(uint64(len(m.Headers)))
+    for _, h := range m.Headers {
+        encodeHeader(e, h)
+    }
+}
+
+func (m *HeadersMessage) decode(d *decoder) {
+    n := d.length()
+    m.Headers = make([]blockchain.BlockHeader, 0, n)
+    for i := 0; i < n && d.err == nil; i++ {
+        m.Headers = append(m.Headers, decodeHeader(d))
+    }
+}
+
+func (m *BlocksMessage) Type() MessageType { return MsgBlocks }
+
+func (m *BlocksMessage) encode(e *encoder) {
+    e.putUvarint(uint64(len(m.Blocks)))
+    for _, b := range m.Blocks {
+        encodeBlock(e, b)
+    }
+}
+
+func (m *BlocksMessage) decode(d *decoder) {
+    n := d.length()
+    m.Blocks = make([]*blockchain.Block, 0, n)
+    for i := 0; i < n && d.err == nil; i++ {
+        m.Blocks = append(m.Blocks, decodeBlock(d))
+    }
+}
+
+func (m *HandshakeMessage) Type() MessageType { return MsgHandshake }
+
+func (m *HandshakeMessage) encode(e *encoder) {
+    e.putUvarint(uint64(m.ProtocolVersion))
+    e.putString(m.ChainID)
+    e.putString(m.GenesisHash)
+    e.putVarint(m.HeadHeight)
+    e.putString(m.HeadHash)
+    encodeStrings(e, m.Capabilities)
+}
+
+func (m *HandshakeMessage) decode(d *decoder) {
+    m.ProtocolVersion = uint32(d.uvarint())
+    m.ChainID = d.string()
+    m.GenesisHash = d.string()
+    m.HeadHeight = d.varint()
+    m.HeadHash = d.string()
+    m.Capabilities = decodeStrings(d)
+}
+
+func (m *TxAnnounceMessage) Type() MessageType { return MsgTxAnnounce }
+
+func (m *TxAnnounceMessage) encode(e *encoder) {
+    encodeStrings(e, m.Hashes)
+}
+
+func (m *TxAnnounceMessage) decode(d *decoder) {
+    m.Hashes = decodeStrings(d)
+}
+
+func (m *GetTxsMessage) Type() MessageType { return MsgGetTxs }
+
+func (m *GetTxsMessage) encode(e *encoder) {
+    encodeStrings(e, m.Hashes)
+}
+
+func (m *GetTxsMessage) decode(d *decoder) {
+    m.Hashes = decodeStrings(d)
+}
+
+func (m *TransactionsMessage) Type() MessageType { return MsgTransactions }
+
+func (m *TransactionsMessage) encode(e *encoder) {
+    e.putUvarint(uint64(len(m.Transactions)))
+    for _, tx := range m.Transactions {
+        encodeTransaction(e, tx)
+    }
+}
+
+func (m *TransactionsMessage) decode(d *decoder) {
+    n := d.length()
+    m.Transactions = make([]blockchain.Transaction, 0, n)
+    for i := 0; i < n && d.err == nil; i++ {
+        m.Transactions = append(m.Transactions, decodeTransaction(d))
+    }
+}
+
+func (m *CompactBlockMessage) Type() MessageType { return MsgCompactBlock }
+
+func (m *CompactBlockMessage) encode(e *encoder) {
+    encodeHeader(e, m.Header)
+    e.putUvarint(uint64(len(m.ShortIDs)))
+    for _, id := range m.ShortIDs {
+        e.putUint48(id)
+    }
+}
+
+func (m *CompactBlockMessage) decode(d *decoder) {
+    m.Header = decodeHeader(d)
+    n := d.length()
+    m.ShortIDs = make([]uint64, 0, n)
+    for i := 0; i < n && d.err == nil; i++ {
+        m.ShortIDs = append(m.ShortIDs, d.uint48())
+    }
+}
+
+func (m *GetBlockTxsMessage) Type() MessageType { return MsgGetBlockTxs }
+
+func (m *GetBlockTxsMessage) encode(e *encoder) {
+    e.putVarint(m.Height)
+    e.putString(m.Hash)
+    e.putUvarint(uint64(len(m.Indexes)))
+    for _, i := range m.Indexes {
+        e.putUvarint(i)
+    }
+}
+
+func (m *GetBlockTxsMessage) decode(d *decoder) {
+    m.Height = d.varint()
+    m.Hash = d.string()
+    n := d.length()
+    m.Indexes = make([]uint64, 0, n)
+    for i := 0; i < n && d.err == nil; i++ {
+        m.Indexes = append(m.Indexes, d.uvarint())
+    }
+}
+
+func (m *BlockTxsMessage) Type() MessageType { return MsgBlockTxs }
+
+func (m *BlockTxsMessage) encode(e *encoder) {
+    e.putString(m.Hash)
+    e.putUvarint(uint64(len(m.Transactions)))
+    for _, tx := range m.Transactions {
+        encodeTransaction(e, tx)
+    }
+}
+
+func (m *BlockTxsMessage) decode(d *decoder) {
+    m.Hash = d.string()
+    n := d.length()
+    m.Transactions = make([]blockchain.Transaction, 0, n)
+    for i := 0; i < n && d.err == nil; i++ {
+        m.Transactions = append(m.Transactions, decodeTransaction(d))
+    }
+}
+
+func encodeStrings(e *encoder, values []string) {
+    e.putUvarint(uint64(len(values)))
+    for _, v := range values {
+        e.putString(v)
+    }
+}
+
+func decodeStrings(d *decoder) []string {
+    n := d.length()
+    values := make([]string, 0, n)
+    for i := 0; i < n && d.err == nil; i++ {
+        values = append(values, d.string())
+    }
+    return values
+}
+
+func encodeTransaction(e *encoder, tx blockchain.Transaction) {
+    e.putString(tx.From)
+    e.putString(tx.To)
+    e.putVarint(tx.Amount)
+    e.putVarint(tx.Nonce)
+}
+
+func decodeTransaction(d *decoder) blockchain.Transaction {
+    return blockchain.Transaction{
+        From:   d.string(),
+        To:     d.string(),
+        Amount: d.varint(),
+        Nonce:  d.varint(),
+    }
+}
+
+func encodeHeader(e *encoder, h blockchain.BlockHeader) {
+    e.putVarint(h.Index)
+    e.putVarint(h.Timestamp)
+    e.putString(h.PrevHash)
+    e.putString(h.Hash)
+    e.putString(h.Validator)
+    e.putVarint(h.Stake)
+}
+
+func decodeHeader(d *decoder) blockchain.BlockHeader {
+    return blockchain.BlockHeader{
+        Index:     d.varint(),
+        Timestamp: d.varint(),
+        PrevHash:  d.string(),
+        Hash:      d.string(),
+        Validator: d.string(),
+        Stake:     d.varint(),
+    }
+}
+
+func encodeBlock(e *encoder, b *blockchain.Block) {
+    encodeHeader(e, b.Header())
+    e.putUvarint(uint64(len(b.Transactions)))
+    for _, tx := range b.Transactions {
+        encodeTransaction(e, tx)
+    }
+}
+
+func decodeBlock(d *decoder) *blockchain.Block {
+    h := decodeHeader(d)
+    n := d.length()
+    txs := make([]blockchain.Transaction, 0, n)
+    for i := 0; i < n && d.err == nil; i++ {
+        txs = append(txs, decodeTransaction(d))
+    }
+    return blockchain.BlockFromHeader(h, txs)
+}
@@ -0,0 +1,207 @@
+package network
+
+import (
+    "io"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// latencyWeight is the weight of a new sample in the latency moving average
+const latencyWeight = 0.1
+
+// Counters is the traffic seen on one protocol or with one peer. Gossip is
+// counted under its topic name and request/response traffic under its
+// protocol ID.
+type Counters struct {
+    MessagesIn  uint64 `json:"messagesIn"`
+    MessagesOut uint64 `json:"messagesOut"`
+    BytesIn     uint64 `json:"bytesIn"`
+    BytesOut    uint64 `json:"bytesOut"`
+    Errors      uint64 `json:"errors"`
+    // Latency is a moving average of request round trips
+    Latency time.Duration `json:"latency"`
+}
+
+func (c *Counters) observeLatency(rtt time.Duration) {
+    if c.Latency == 0 {
+        c.Latency = rtt
+        return
+    }
+    c.Latency = time.Duration((1-latencyWeight)*float64(c.Latency) + latencyWeight*float64(rtt))
+}
+
+// Metrics is a snapshot of the node's network counters
+type Metrics struct {
+    Protocols map[string]Counters  `json:"protocols"`
+    Peers     map[peer.ID]Counters `json:"peers"`
+}
+
+// networkMetrics accumulates counters per protocol and per peer
+type networkMetrics struct {
+    mu        sync.Mutex
+    protocols map[string]*Counters
+    peers     map[peer.ID]*Counters
+}
+
+func newNetworkMetrics() *networkMetrics {
+    return &networkMetrics{
+        protocols: make(map[string]*Counters),
+        peers:     make(map[peer.ID]*Counters),
+    }
+}
+
+// update applies fn to the counters of proto and, if id is set, of id
+func (m *networkMetrics) update(proto string, id peer.ID, fn func(c *Counters)) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    c, exists := m.protocols[proto]
+    if !exists {
+        c = &Counters{}
+        m.protocols[proto] = c
+    }
+    fn(c)
+
+    if id == "" {
+        return
+    }
+    c, exists = m.peers[id]
+    if !exists {
+        c = &Counters{}
+        m.peers[id] = c
+    }
+    fn(c)
+}
+
+func (m *networkMetrics) received(proto string, id peer.ID, bytes int) {
+    m.update(proto, id, func(c *Counters) {
+        c.MessagesIn++
+        c.BytesIn += uint64(bytes)
+    })
+}
+
+func (m *networkMetrics) sent(proto string, id peer.ID, bytes int) {
+    m.update(proto, id, func(c *Counters) {
+        c.MessagesOut++
+        c.BytesOut += uint64(bytes)
+    })
+}
+
+func (m *networkMetrics) failed(proto string, id peer.ID) {
+    m.update(proto, id, func(c *Counters) { c.Errors++ })
+}
+
+func (m *networkMetrics) roundTrip(proto string, id peer.ID, rtt time.Duration) {
+    m.update(proto, id, func(c *Counters) { c.observeLatency(rtt) })
+}
+
+func (m *networkMetrics) peer(id peer.ID) Counters {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if c, exists := m.peers[id]; exists {
+        return *c
+    }
+    return Counters{}
+}
+
+func (m *networkMetrics) forget(id peer.ID) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    delete(m.peers, id)
+}
+
+func (m *networkMetrics) snapshot() Metrics {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    s := Metrics{
+        Protocols: make(map[string]Counters, len(m.protocols)),
+        Peers:     make(map[peer.ID]Counters, len(m.peers)),
+    }
+    for proto, c := range m.protocols {
+        s.Protocols[proto] = *c
+    }
+    for id, c := range m.peers {
+        s.Peers[id] = *c
+    }
+    return s
+}
+
+// Metrics returns the traffic counters of the node. Peer counters are
+// dropped when the peer disconnects.
+func (p *P2PNetwork) Metrics() Metrics {
+    return p.metrics.snapshot()
+}
+
+// PeerInfo describes a connected peer that completed the handshake
+type PeerInfo struct {
+    ID              peer.ID   `json:"id"`
+    Addrs           []string  `json:"addrs"`
+    Inbound         bool      `json:"inbound"`
+    ConnectedAt     time.Time `json:"connectedAt"`
+    ProtocolVersion uint32    `json:"protocolVersion"`
+    HeadHeight      int64     `json:"headHeight"`
+    HeadHash        string    `json:"headHash"`
+    Capabilities    []string  `json:"capabilities"`
+    Score           float64   `json:"score"`
+    // Latency is the round-trip estimate kept by the peerstore
+    Latency  time.Duration `json:"latency"`
+    Counters Counters      `json:"counters"`
+}
+
+// Peers returns the peers that completed the handshake, ordered by ID
+func (p *P2PNetwork) Peers() []PeerInfo {
+    p.peersMu.RLock()
+    statuses := make([]*PeerStatus, 0, len(p.peers))
+    for _, status := range p.peers {
+        statuses = append(statuses, status)
+    }
+    p.peersMu.RUnlock()
+
+    infos := make([]PeerInfo, 0, len(statuses))
+    for _, status := range statuses {
+        info := PeerInfo{
+            ID:              status.ID,
+            ConnectedAt:     status.ConnectedAt,
+            ProtocolVersion: status.Handshake.ProtocolVersion,
+            HeadHeight:      status.Handshake.HeadHeight,
+            HeadHash:        status.Handshake.HeadHash,
+            Capabilities:    status.Handshake.Capabilities,
+            Score:           p.PeerScore(status.ID),
+            Latency:         p.host.Peerstore().LatencyEWMA(status.ID),
+            Counters:        p.metrics.peer(status.ID),
+        }
+        for _, conn := range p.host.Network().ConnsToPeer(status.ID) {
+            info.Addrs = append(info.Addrs, conn.RemoteMultiaddr().String())
+            if conn.Stat().Direction == network.DirInbound {
+                info.Inbound = true
+            }
+        }
+        infos = append(infos, info)
+    }
+    sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+    return infos
+}
+
+// meteredStream counts the bytes read from and written to a stream
+type meteredStream struct {
+    rw      io.ReadWriter
+    in, out int
+}
+
+func (s *meteredStream) Read(b []byte) (int, error) {
+    n, err := s.rw.Read(b)
+    s.in += n
+    return n, err
+}
+
+func (s *meteredStream) Write(b []byte) (int, error) {
+    n, err := s.rw.Write(b)
+    s.out += n
+    return n, err
+}
@@ -2,60 +2,179 @@ package network
 
 import (
     "context"
+    "crypto/rand"
     "fmt"
     "log"
+    "sync"
     "time"
 
-    "github.com/libp2p/go-libp2p"
+    pubsub "github.com/libp2p/go-libp2p-pubsub"
+    "github.com/libp2p/go-libp2p/core/crypto"
     "github.com/libp2p/go-libp2p/core/host"
     "github.com/libp2p/go-libp2p/core/peer"
+    dht "github.com/libp2p/go-libp2p-kad-dht"
+    "github.com/libp2p/go-libp2p/core/discovery"
     "github.com/libp2p/go-libp2p/p2p/discovery/mdns"
     "github.com/multiformats/go-multiaddr"
     "github.com/bonniegachiengu/sustena_platforms/config"
+    "github.com/bonniegachiengu/sustena_platforms/entropy/blockchain"
     "golang.org/x/time/rate"
 )
 
+// DefaultChainID is used when the configuration doesn't name a chain
+const DefaultChainID = "sustena-devnet"
+
+// Defaults for settings missing from the network configuration
+const (
+    defaultDataDir       = "./data"
+    defaultConnLowWater  = 32
+    defaultConnHighWater = 64
+    defaultPeerRateLimit = 50
+    defaultPeerRateBurst = 100
+    defaultTargetPeers   = 16
+)
+
 type P2PNetwork struct {
     host host.Host
     ctx  context.Context
+    cancel context.CancelFunc
     logger *log.Logger
     limiter *rate.Limiter
+    chainID string
+    chain   *blockchain.Blockchain
+    config  config.NetworkConfig
+
+    pubsub *pubsub.PubSub
+    topics map[string]*pubsub.Topic
+    hooks  map[string]GossipHook
+    mu     sync.Mutex
+
+    peers     map[peer.ID]*PeerStatus
+    listeners []PeerListener
+    peersMu   sync.RWMutex
+
+    bans    *BanList
+    scorer  *peerScorer
+    metrics *networkMetrics
+
+    mdns      mdns.Service
+    dht       *dht.IpfsDHT
+    discovery discovery.Discovery
+    bootstrap []peer.AddrInfo
+}
+
+// NewP2PNetwork starts a node with LoadNetworkConfig on the libp2p
+// transport, listening on listenAddr if it isn't empty
+func NewP2PNetwork(listenAddr string, chain *blockchain.Blockchain) (*P2PNetwork, error) {
+    networkConfig := LoadNetworkConfig()
+    if listenAddr != "" {
+        networkConfig.ListenAddr = listenAddr
+    }
+    return NewP2PNetworkWithTransport(networkConfig, chain, LibP2PTransport{})
 }
 
-func NewP2PNetwork(listenAddr string) (*P2PNetwork, error) {
-    ctx := context.Background()
+// NewP2PNetworkWithTransport starts a node with networkConfig on the given
+// transport. Missing settings are not defaulted; see LoadNetworkConfig. An
+// empty DataDir gives the node a throwaway identity and a ban list that
+// isn't saved.
+func NewP2PNetworkWithTransport(networkConfig config.NetworkConfig, chain *blockchain.Blockchain, transport Transport) (*P2PNetwork, error) {
+    ctx, cancel := context.WithCancel(context.Background())
+
+    identity, err := nodeIdentity(networkConfig.DataDir)
+    if err != nil {
+        cancel()
+        return nil, err
+    }
+    bans, err := LoadBanList(networkConfig.DataDir)
+    if err != nil {
+        cancel()
+        return nil, err
+    }
 
-    networkConfig := config.GetNetworkConfig()
-    if networkConfig.ListenAddr == "" {
-        networkConfig.ListenAddr = "/ip4/0.0.0.0/tcp/4001"
+    allowed, err := parseAllowedPeers(networkConfig.AllowedPeers)
+    if err != nil {
+        cancel()
+        return nil, err
     }
 
-    h, err := libp2p.New(
-        libp2p.ListenAddrStrings(networkConfig.ListenAddr),
-    )
+    h, err := transport.NewHost(identity, networkConfig, &connectionGater{bans: bans, allowed: allowed})
     if err != nil {
+        cancel()
         return nil, fmt.Errorf("failed to create libp2p host: %w", err)
     }
 
     p2p := &P2PNetwork{
         host: h,
         ctx:  ctx,
+        cancel: cancel,
         logger: log.New(log.Writer(), "", log.LstdFlags),
         limiter: rate.NewLimiter(rate.Every(time.Second), 1), // 1 log per second
+        chainID: networkConfig.ChainID,
+        chain: chain,
+        config: networkConfig,
+        topics: make(map[string]*pubsub.Topic),
+        hooks: make(map[string]GossipHook),
+        peers: make(map[peer.ID]*PeerStatus),
+        bans: bans,
+        scorer: newPeerScorer(networkConfig.PeerRateLimit, networkConfig.PeerRateBurst, h.ConnManager()),
+        metrics: newNetworkMetrics(),
+    }
+
+    p2p.setupHandshake()
+
+    if err := p2p.setupPubSub(); err != nil {
+        p2p.Shutdown()
+        return nil, fmt.Errorf("failed to setup pubsub: %w", err)
     }
 
     if err := p2p.setupDiscovery(); err != nil {
+        p2p.Shutdown()
         return nil, fmt.Errorf("failed to setup discovery: %w", err)
     }
 
-    p2p.logger.Printf("P2P network initialized. Listening on: %s", networkConfig.ListenAddr)
+    p2p.logger.Printf("P2P network initialized as %s. Listening on: %s", h.ID(), networkConfig.ListenAddr)
     return p2p, nil
 }
 
-func (p *P2PNetwork) setupDiscovery() error {
-    // Setup local mDNS discovery
-    s := mdns.NewMdnsService(p.host, "sustena-network", &discoveryNotifee{p: p})
-    return s.Start()
+// nodeIdentity loads the identity stored in dataDir, or generates an
+// unsaved one if dataDir is empty
+func nodeIdentity(dataDir string) (crypto.PrivKey, error) {
+    if dataDir == "" {
+        key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+        return key, err
+    }
+    return LoadIdentity(dataDir)
+}
+
+// LoadNetworkConfig returns the network configuration with defaults filled
+// in for missing settings
+func LoadNetworkConfig() config.NetworkConfig {
+    c := config.GetNetworkConfig()
+    if c.ListenAddr == "" {
+        c.ListenAddr = "/ip4/0.0.0.0/tcp/4001"
+    }
+    if c.ChainID == "" {
+        c.ChainID = DefaultChainID
+    }
+    if c.DataDir == "" {
+        c.DataDir = defaultDataDir
+    }
+    if c.ConnLowWater == 0 {
+        c.ConnLowWater = defaultConnLowWater
+    }
+    if c.ConnHighWater == 0 {
+        c.ConnHighWater = defaultConnHighWater
+    }
+    if c.PeerRateLimit == 0 {
+        c.PeerRateLimit = defaultPeerRateLimit
+    }
+    if c.PeerRateBurst == 0 {
+        c.PeerRateBurst = defaultPeerRateBurst
+    }
+    if c.TargetPeers == 0 {
+        c.TargetPeers = defaultTargetPeers
+    }
+    return c
 }
 
 func (p *P2PNetwork) Connect(peerAddr string) error {
@@ -73,37 +192,32 @@ func (p *P2PNetwork) Connect(peerAddr string) error {
         return err
     }
 
-    fmt.Printf("Connected to peer: %s\n", peerInfo.ID.String())
-    return nil
-}
-
-func (p *P2PNetwork) Broadcast(msg []byte) error {
-    // Implement message broadcasting to all connected peers
-    // This is a placeholder and should be implemented based on your specific needs
+    p.logger.Printf("Connected to peer: %s", peerInfo.ID)
     return nil
 }
 
-func (p *P2PNetwork) ReceiveMessage() []byte {
-    // Implement message receiving logic
-    // This is a placeholder and should be implemented based on your specific needs
-    return nil
+// ChainID returns the chain this node participates in
+func (p *P2PNetwork) ChainID() string {
+    return p.chainID
 }
 
 func (p *P2PNetwork) Shutdown() error {
-    return p.host.Close()
-}
+    p.cancel()
 
-// discoveryNotifee gets notified when we find a new peer via mDNS discovery
-type discoveryNotifee struct {
-    p *P2PNetwork
-}
+    p.mu.Lock()
+    for name, topic := range p.topics {
+        topic.Close()
+        delete(p.topics, name)
+    }
+    p.mu.Unlock()
 
-func (n *discoveryNotifee) HandlePeerFound(pi peer.AddrInfo) {
-    fmt.Printf("Discovered new peer %s\n", pi.ID.String())
-    err := n.p.host.Connect(n.p.ctx, pi)
-    if err != nil {
-        fmt.Printf("Error connecting to peer %s: %s\n", pi.ID.String(), err)
+    if p.mdns != nil {
+        p.mdns.Close()
     }
+    if p.dht != nil {
+        p.dht.Close()
+    }
+    return p.host.Close()
 }
 
 // Use this method to log deployments
@@ -0,0 +1,72 @@
+package network
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "fmt"
+    "os"
+
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/pnet"
+    "github.com/multiformats/go-multiaddr"
+)
+
+// pskSize is the length of a libp2p pre-shared key
+const pskSize = 32
+
+// LoadPSK reads a pre-shared key in the libp2p swarm.key format
+func LoadPSK(path string) (pnet.PSK, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open pre-shared key: %w", err)
+    }
+    defer f.Close()
+
+    psk, err := pnet.DecodeV1PSK(f)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse pre-shared key %s: %w", path, err)
+    }
+    return psk, nil
+}
+
+// GeneratePSK returns a new random pre-shared key in the swarm.key format
+func GeneratePSK() ([]byte, error) {
+    key := make([]byte, pskSize)
+    if _, err := rand.Read(key); err != nil {
+        return nil, err
+    }
+    return []byte("/key/swarm/psk/1.0.0/\n/base16/\n" + hex.EncodeToString(key) + "\n"), nil
+}
+
+// checkPrivateListenAddr rejects listen addresses whose transports do their
+// own encryption and so can't be wrapped by the pre-shared key. libp2p only
+// runs TCP and WebSocket in a private network.
+func checkPrivateListenAddr(addr string) error {
+    maddr, err := multiaddr.NewMultiaddr(addr)
+    if err != nil {
+        return fmt.Errorf("invalid listen address %q: %w", addr, err)
+    }
+    for _, p := range maddr.Protocols() {
+        switch p.Code {
+        case multiaddr.P_QUIC, multiaddr.P_QUIC_V1, multiaddr.P_WEBTRANSPORT, multiaddr.P_WEBRTC_DIRECT:
+            return fmt.Errorf("listen address %s uses %s, which is not supported in a private network", addr, p.Name)
+        }
+    }
+    return nil
+}
+
+// parseAllowedPeers returns the allowlist, or nil if every peer is allowed
+func parseAllowedPeers(ids []string) (map[peer.ID]struct{}, error) {
+    if len(ids) == 0 {
+        return nil, nil
+    }
+    allowed := make(map[peer.ID]struct{}, len(ids))
+    for _, s := range ids {
+        id, err := peer.Decode(s)
+        if err != nil {
+            return nil, fmt.Errorf("invalid allowed peer %q: %w", s, err)
+        }
+        allowed[id] = struct{}{}
+    }
+    return allowed, nil
+}
@@ -0,0 +1,223 @@
+package network
+
+import (
+    "context"
+    "crypto/sha256"
+    "fmt"
+    "time"
+
+    pubsub "github.com/libp2p/go-libp2p-pubsub"
+    pb "github.com/libp2p/go-libp2p-pubsub/pb"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Gossip topics used by the Sustena network
+const (
+    TopicBlocks       = "/sustena/blocks/1.0.0"
+    TopicTransactions = "/sustena/transactions/1.0.0"
+    TopicVotes        = "/sustena/votes/1.0.0"
+)
+
+// Topics lists every gossip topic a node joins on startup
+var Topics = []string{TopicBlocks, TopicTransactions, TopicVotes}
+
+// seenMessagesTTL is how long a message ID is remembered for deduplication
+const seenMessagesTTL = 2 * time.Minute
+
+// subscriptionBuffer is the number of messages buffered per subscriber
+const subscriptionBuffer = 64
+
+// Message is a decoded gossip message received from the network
+type Message struct {
+    Topic string
+    // From is the peer that published the message and ReceivedFrom the
+    // peer that relayed it to us
+    From         peer.ID
+    ReceivedFrom peer.ID
+    Payload      Payload
+    ReceivedAt   time.Time
+}
+
+// messageID derives the pubsub message ID from the publisher and payload,
+// so a message reaching us over several paths, or republished by the same
+// peer, is only delivered once. Relays that republish a payload under
+// their own ID (such as transaction announcements) are not suppressed.
+func messageID(m *pb.Message) string {
+    h := sha256.New()
+    h.Write(m.From)
+    h.Write(m.Data)
+    return string(h.Sum(nil))
+}
+
+func (p *P2PNetwork) setupPubSub() error {
+    ps, err := pubsub.NewGossipSub(p.ctx, p.host,
+        pubsub.WithMessageIdFn(messageID),
+        pubsub.WithSeenMessagesTTL(seenMessagesTTL),
+    )
+    if err != nil {
+        return err
+    }
+    p.pubsub = ps
+
+    for _, name := range Topics {
+        if err := ps.RegisterTopicValidator(name, p.validateGossip); err != nil {
+            return fmt.Errorf("failed to register validator for %s: %w", name, err)
+        }
+        if _, err := p.joinTopic(name); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// validateGossip runs before a message is delivered or relayed. Peers that
+// exceed their rate limit have messages ignored; malformed messages or
+// messages for another chain are rejected and count against the relayer.
+func (p *P2PNetwork) validateGossip(_ context.Context, from peer.ID, m *pubsub.Message) pubsub.ValidationResult {
+    if from == p.host.ID() {
+        return pubsub.ValidationAccept
+    }
+    topic := m.GetTopic()
+    p.metrics.received(topic, from, len(m.Data))
+    if !p.allowInbound(from) {
+        p.metrics.failed(topic, from)
+        return pubsub.ValidationIgnore
+    }
+
+    env, msg, err := DecodeMessage(m.Data)
+    if err != nil {
+        p.metrics.failed(topic, from)
+        p.ReportPeer(from, PenaltyInvalidMsg, fmt.Sprintf("malformed gossip: %v", err))
+        return pubsub.ValidationReject
+    }
+    if env.ChainID != p.chainID {
+        p.metrics.failed(topic, from)
+        p.ReportPeer(from, PenaltyInvalidMsg, fmt.Sprintf("gossip for chain %q", env.ChainID))
+        return pubsub.ValidationReject
+    }
+    if want, err := topicFor(env.Type); err != nil || want != topic {
+        p.metrics.failed(topic, from)
+        p.ReportPeer(from, PenaltyInvalidMsg, fmt.Sprintf("%s message on topic %s", env.Type, topic))
+        return pubsub.ValidationReject
+    }
+
+    p.mu.Lock()
+    hook := p.hooks[topic]
+    p.mu.Unlock()
+    if hook != nil && !hook(from, msg) {
+        return pubsub.ValidationIgnore
+    }
+    return pubsub.ValidationAccept
+}
+
+// GossipHook inspects a decoded gossip message before it is delivered to
+// subscribers or relayed. Returning false consumes the message: it is
+// neither delivered nor forwarded to other peers.
+type GossipHook func(from peer.ID, msg Payload) bool
+
+// SetGossipHook installs hook for topic, replacing any previous hook
+func (p *P2PNetwork) SetGossipHook(topic string, hook GossipHook) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.hooks[topic] = hook
+}
+
+// joinTopic returns the handle for a topic, joining it on first use
+func (p *P2PNetwork) joinTopic(name string) (*pubsub.Topic, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if topic, exists := p.topics[name]; exists {
+        return topic, nil
+    }
+    topic, err := p.pubsub.Join(name)
+    if err != nil {
+        return nil, fmt.Errorf("failed to join topic %s: %w", name, err)
+    }
+    p.topics[name] = topic
+    return topic, nil
+}
+
+// Broadcast publishes msg to every peer subscribed to topic
+func (p *P2PNetwork) Broadcast(topic string, msg []byte) error {
+    t, err := p.joinTopic(topic)
+    if err != nil {
+        return err
+    }
+    if err := t.Publish(p.ctx, msg); err != nil {
+        p.metrics.failed(topic, "")
+        return err
+    }
+    p.metrics.sent(topic, "", len(msg))
+    return nil
+}
+
+// Publish encodes msg for this node's chain and broadcasts it on the topic
+// for its type
+func (p *P2PNetwork) Publish(msg Payload) error {
+    topic, err := topicFor(msg.Type())
+    if err != nil {
+        return err
+    }
+    return p.Broadcast(topic, EncodeMessage(p.chainID, msg))
+}
+
+func topicFor(t MessageType) (string, error) {
+    switch t {
+    case MsgBlock, MsgCompactBlock:
+        return TopicBlocks, nil
+    case MsgTransaction, MsgTxAnnounce:
+        return TopicTransactions, nil
+    case MsgVote:
+        return TopicVotes, nil
+    default:
+        return "", fmt.Errorf("%s messages are not gossiped", t)
+    }
+}
+
+// Subscribe delivers messages published on topic by other peers, decoded.
+// Messages that don't decode are dropped and count against the peer that
+// relayed them. The channel is closed when the network shuts down.
+func (p *P2PNetwork) Subscribe(topic string) (<-chan *Message, error) {
+    t, err := p.joinTopic(topic)
+    if err != nil {
+        return nil, err
+    }
+    sub, err := t.Subscribe()
+    if err != nil {
+        return nil, fmt.Errorf("failed to subscribe to topic %s: %w", topic, err)
+    }
+
+    out := make(chan *Message, subscriptionBuffer)
+    go func() {
+        defer close(out)
+        defer sub.Cancel()
+        for {
+            m, err := sub.Next(p.ctx)
+            if err != nil {
+                return
+            }
+            // Our own publications are delivered locally as well
+            if m.ReceivedFrom == p.host.ID() {
+                continue
+            }
+            _, msg, err := DecodeMessage(m.Data)
+            if err != nil {
+                p.ReportPeer(m.ReceivedFrom, PenaltyInvalidMsg, fmt.Sprintf("malformed gossip: %v", err))
+                continue
+            }
+            select {
+            case out <- &Message{
+                Topic:        topic,
+                From:         m.GetFrom(),
+                ReceivedFrom: m.ReceivedFrom,
+                Payload:      msg,
+                ReceivedAt:   time.Now(),
+            }:
+            case <-p.ctx.Done():
+                return
+            }
+        }
+    }()
+    return out, nil
+}
@@ -0,0 +1,198 @@
+package network
+
+import (
+    "math"
+    "sync"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/connmgr"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "golang.org/x/time/rate"
+)
+
+// Score adjustments applied for peer behaviour
+const (
+    RewardUsefulMessage  = 1.0
+    RewardUsefulBlock    = 5.0
+    PenaltyRateLimited   = -5.0
+    PenaltyInvalidMsg    = -20.0
+    PenaltyInvalidBlock  = -50.0
+    PenaltyFailedRequest = -10.0
+)
+
+const (
+    // banThreshold is the score at which a peer is banned
+    banThreshold = -100.0
+    // tempBanDuration is the length of a temporary ban
+    tempBanDuration = time.Hour
+    // maxTempBans is the number of temporary bans before a ban is persistent
+    maxTempBans = 3
+    // scoreHalfLife is how long it takes a score to decay halfway to zero
+    scoreHalfLife = 10 * time.Minute
+    // maxScore caps the credit a peer can build up
+    maxScore = 100.0
+    // scoreRetention is how long a disconnected peer's negative score is
+    // kept, so that reconnecting doesn't clear it
+    scoreRetention = time.Hour
+
+    // scoreTag is the connection manager tag that carries the peer score
+    scoreTag = "sustena-score"
+)
+
+type peerScore struct {
+    value   float64
+    updated time.Time
+    limiter *rate.Limiter
+    // left is when the peer disconnected, or zero while it is connected
+    left time.Time
+}
+
+// decayed returns the score at now, moving it exponentially towards zero
+func (s *peerScore) decayed(now time.Time) float64 {
+    elapsed := now.Sub(s.updated)
+    return s.value * math.Pow(0.5, float64(elapsed)/float64(scoreHalfLife))
+}
+
+// peerScorer tracks the reputation and inbound rate of every peer
+type peerScorer struct {
+    scores map[peer.ID]*peerScore
+    mu     sync.Mutex
+
+    rateLimit rate.Limit
+    rateBurst int
+    tagger    connmgr.ConnManager
+}
+
+func newPeerScorer(rateLimit float64, rateBurst int, tagger connmgr.ConnManager) *peerScorer {
+    return &peerScorer{
+        scores:    make(map[peer.ID]*peerScore),
+        rateLimit: rate.Limit(rateLimit),
+        rateBurst: rateBurst,
+        tagger:    tagger,
+    }
+}
+
+// get returns the entry for id, creating it on first use; the caller must
+// hold mu
+func (s *peerScorer) get(id peer.ID) *peerScore {
+    ps, exists := s.scores[id]
+    if !exists {
+        ps = &peerScore{
+            updated: time.Now(),
+            limiter: rate.NewLimiter(s.rateLimit, s.rateBurst),
+        }
+        s.scores[id] = ps
+    }
+    ps.left = time.Time{}
+    return ps
+}
+
+// adjust applies delta to a peer's score and returns the new value
+func (s *peerScorer) adjust(id peer.ID, delta float64) float64 {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := time.Now()
+    ps := s.get(id)
+    ps.value = math.Min(ps.decayed(now)+delta, maxScore)
+    ps.updated = now
+    s.tagger.TagPeer(id, scoreTag, int(ps.value))
+    return ps.value
+}
+
+func (s *peerScorer) score(id peer.ID) float64 {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    ps, exists := s.scores[id]
+    if !exists {
+        return 0
+    }
+    return ps.decayed(time.Now())
+}
+
+// allow reports whether an inbound message from id fits its rate limit
+func (s *peerScorer) allow(id peer.ID) bool {
+    s.mu.Lock()
+    ps := s.get(id)
+    s.mu.Unlock()
+    return ps.limiter.Allow()
+}
+
+func (s *peerScorer) forget(id peer.ID) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.scores, id)
+}
+
+// disconnected drops the entry of a peer that has gone, unless its score
+// is negative, in which case it is kept for scoreRetention. Entries kept
+// that long are dropped as well.
+func (s *peerScorer) disconnected(id peer.ID) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := time.Now()
+    if ps, exists := s.scores[id]; exists {
+        if ps.decayed(now) >= 0 {
+            delete(s.scores, id)
+        } else {
+            ps.left = now
+        }
+    }
+    for other, ps := range s.scores {
+        if !ps.left.IsZero() && now.Sub(ps.left) > scoreRetention {
+            delete(s.scores, other)
+        }
+    }
+}
+
+// ReportPeer adjusts a peer's score by delta. Peers whose score falls to
+// the ban threshold are disconnected and banned, temporarily at first and
+// persistently once they have been banned maxTempBans times.
+func (p *P2PNetwork) ReportPeer(id peer.ID, delta float64, reason string) {
+    if id == "" || id == p.host.ID() {
+        return
+    }
+    if p.scorer.adjust(id, delta) > banThreshold {
+        return
+    }
+
+    duration := tempBanDuration
+    if p.bans.count(id) >= maxTempBans {
+        duration = 0
+    }
+    p.BanPeer(id, duration, reason)
+}
+
+// BanPeer disconnects id and refuses further connections for duration, or
+// persistently if duration is zero
+func (p *P2PNetwork) BanPeer(id peer.ID, duration time.Duration, reason string) {
+    ban, err := p.bans.Ban(id, duration, reason)
+    if err != nil {
+        p.logger.Printf("Failed to persist ban for %s: %v", id, err)
+    }
+    if ban.Until.IsZero() {
+        p.logger.Printf("Banned peer %s persistently: %s", id, reason)
+    } else {
+        p.logger.Printf("Banned peer %s until %s: %s", id, ban.Until.Format(time.RFC3339), reason)
+    }
+    p.scorer.forget(id)
+    p.removePeer(id)
+    p.host.Network().ClosePeer(id)
+}
+
+// PeerScore returns a peer's current score
+func (p *P2PNetwork) PeerScore(id peer.ID) float64 {
+    return p.scorer.score(id)
+}
+
+// allowInbound applies the per-peer rate limit to an inbound message,
+// penalising peers that exceed it
+func (p *P2PNetwork) allowInbound(id peer.ID) bool {
+    if p.scorer.allow(id) {
+        return true
+    }
+    p.ReportPeer(id, PenaltyRateLimited, "inbound rate limit exceeded")
+    return false
+}
@@ -0,0 +1,136 @@
+package network
+
+import (
+    "bufio"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "time"
+
+    "github.com/libp2p/go-libp2p/core/network"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// streamTimeout bounds a single request/response exchange
+const streamTimeout = 30 * time.Second
+
+// writeFrame writes msg as a length-prefixed envelope
+func writeFrame(w io.Writer, chainID string, msg Payload) error {
+    data := EncodeMessage(chainID, msg)
+    var prefix [binary.MaxVarintLen64]byte
+    n := binary.PutUvarint(prefix[:], uint64(len(data)))
+    if _, err := w.Write(prefix[:n]); err != nil {
+        return err
+    }
+    _, err := w.Write(data)
+    return err
+}
+
+// readEnvelope reads a length-prefixed envelope from any chain
+func readEnvelope(r *bufio.Reader) (*Envelope, Payload, error) {
+    size, err := binary.ReadUvarint(r)
+    if err != nil {
+        return nil, nil, err
+    }
+    if size > maxFieldSize {
+        return nil, nil, fmt.Errorf("frame of %d bytes exceeds limit", size)
+    }
+    data := make([]byte, size)
+    if _, err := io.ReadFull(r, data); err != nil {
+        return nil, nil, err
+    }
+    return DecodeMessage(data)
+}
+
+// readFrame reads a length-prefixed envelope and rejects other chains
+func readFrame(r *bufio.Reader, chainID string) (Payload, error) {
+    env, msg, err := readEnvelope(r)
+    if err != nil {
+        return nil, err
+    }
+    if env.ChainID != chainID {
+        return nil, fmt.Errorf("%w: got %q, want %q", ErrChainIDMismatch, env.ChainID, chainID)
+    }
+    return msg, nil
+}
+
+// request opens a stream to a peer, sends req and reads a single response
+func (p *P2PNetwork) request(id peer.ID, proto protocol.ID, req Payload) (Payload, error) {
+    resp, err := p.roundTrip(id, proto, req)
+    if err != nil {
+        p.metrics.failed(string(proto), id)
+    }
+    return resp, err
+}
+
+func (p *P2PNetwork) roundTrip(id peer.ID, proto protocol.ID, req Payload) (Payload, error) {
+    start := time.Now()
+    s, err := p.host.NewStream(p.ctx, id, proto)
+    if err != nil {
+        return nil, err
+    }
+    defer s.Close()
+    s.SetDeadline(time.Now().Add(streamTimeout))
+
+    ms := &meteredStream{rw: s}
+    if err := writeFrame(ms, p.chainID, req); err != nil {
+        s.Reset()
+        return nil, err
+    }
+    p.metrics.sent(string(proto), id, ms.out)
+    if err := s.CloseWrite(); err != nil {
+        s.Reset()
+        return nil, err
+    }
+    resp, err := readFrame(bufio.NewReader(ms), p.chainID)
+    if err != nil {
+        s.Reset()
+        return nil, err
+    }
+    rtt := time.Since(start)
+    p.metrics.received(string(proto), id, ms.in)
+    p.metrics.roundTrip(string(proto), id, rtt)
+    p.host.Peerstore().RecordLatency(id, rtt)
+    return resp, nil
+}
+
+// serve registers a request/response handler for proto. The handler's
+// reply is written back on the same stream.
+func (p *P2PNetwork) serve(proto protocol.ID, handle func(from peer.ID, req Payload) (Payload, error)) {
+    name := string(proto)
+    p.host.SetStreamHandler(proto, func(s network.Stream) {
+        defer s.Close()
+        s.SetDeadline(time.Now().Add(streamTimeout))
+
+        from := s.Conn().RemotePeer()
+        if !p.allowInbound(from) {
+            p.metrics.failed(name, from)
+            s.Reset()
+            return
+        }
+        ms := &meteredStream{rw: s}
+        req, err := readFrame(bufio.NewReader(ms), p.chainID)
+        if err != nil {
+            p.logger.Printf("Invalid %s request from %s: %v", proto, from, err)
+            p.metrics.failed(name, from)
+            p.ReportPeer(from, PenaltyInvalidMsg, fmt.Sprintf("invalid %s request", proto))
+            s.Reset()
+            return
+        }
+        p.metrics.received(name, from, ms.in)
+        resp, err := handle(from, req)
+        if err != nil {
+            p.logger.Printf("Failed to serve %s request from %s: %v", proto, from, err)
+            p.metrics.failed(name, from)
+            s.Reset()
+            return
+        }
+        if err := writeFrame(ms, p.chainID, resp); err != nil {
+            p.metrics.failed(name, from)
+            s.Reset()
+            return
+        }
+        p.metrics.sent(name, from, ms.out)
+    })
+}
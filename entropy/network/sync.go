@@ -0,0 +1,497 @@
+package network
+
+import (
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/bonniegachiengu/sustena_platforms/entropy/blockchain"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// SyncProtocol is the request/response protocol used to fetch blocks
+const SyncProtocol = protocol.ID("/sustena/sync/1.0.0")
+
+// ProtocolVersion is the version of the Sustena peer protocols
+const ProtocolVersion uint32 = 1
+
+const (
+    // maxBlocksPerRequest caps the range served for a single request
+    maxBlocksPerRequest = 128
+    // syncBatchSize is the number of bodies requested from a peer at a time
+    syncBatchSize = 32
+    // maxSyncRound caps the blocks downloaded before applying them
+    maxSyncRound = 1024
+    // syncInterval is how often peers are polled for their head
+    syncInterval = 10 * time.Second
+)
+
+// BlockValidator checks a block before the sync manager applies it.
+// Validators wrap ErrInvalidBlock when the block itself proves the peer
+// that sent it misbehaved, such as a block whose hash doesn't match its
+// contents. Other rejections, like a validator missing from the local
+// validator set, may be our own view being out of date, so the peer isn't
+// penalised for them.
+type BlockValidator func(block *blockchain.Block) error
+
+// ErrInvalidBlock marks blocks no honest peer would send
+var ErrInvalidBlock = errors.New("invalid block")
+
+// errInvalidData marks responses that prove the peer is misbehaving, as
+// opposed to requests that merely failed
+var errInvalidData = errors.New("invalid data")
+
+// pendingBlock is a downloaded block and the peer that served it
+type pendingBlock struct {
+    block *blockchain.Block
+    from  peer.ID
+}
+
+// SyncManager brings the local chain up to date with peers that are ahead.
+// Headers are fetched from the best peer, bodies are downloaded in parallel
+// from every peer that has them, and blocks are applied strictly in order.
+type SyncManager struct {
+    p2p      *P2PNetwork
+    chain    *blockchain.Blockchain
+    validate BlockValidator
+
+    mu sync.Mutex
+    // heads holds the last status reported by each peer
+    heads map[peer.ID]StatusMessage
+    // pending holds verified bodies that were downloaded but not yet
+    // applied, so an interrupted round doesn't download them again
+    pending map[int64]pendingBlock
+
+    trigger chan struct{}
+    syncing sync.Mutex
+}
+
+func NewSyncManager(p2p *P2PNetwork, validate BlockValidator) *SyncManager {
+    s := &SyncManager{
+        p2p:      p2p,
+        chain:    p2p.chain,
+        validate: validate,
+        heads:    make(map[peer.ID]StatusMessage),
+        pending:  make(map[int64]pendingBlock),
+        trigger:  make(chan struct{}, 1),
+    }
+    p2p.serve(SyncProtocol, s.handleRequest)
+    p2p.AddPeerListener(s)
+    return s
+}
+
+// Start polls peers and syncs in the background until the network shuts down
+func (s *SyncManager) Start() {
+    go func() {
+        ticker := time.NewTicker(syncInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-s.p2p.ctx.Done():
+                return
+            case <-ticker.C:
+            case <-s.trigger:
+            }
+            if err := s.Sync(); err != nil {
+                s.p2p.logger.Printf("Block sync interrupted: %v", err)
+            }
+        }
+    }()
+}
+
+// Trigger schedules a sync round, e.g. after a block from the future
+// arrives over gossip
+func (s *SyncManager) Trigger() {
+    select {
+    case s.trigger <- struct{}{}:
+    default:
+    }
+}
+
+// Status describes the local chain as reported to peers
+func (s *SyncManager) Status() StatusMessage {
+    head := s.chain.GetLastBlock()
+    return StatusMessage{
+        ProtocolVersion: ProtocolVersion,
+        GenesisHash:     s.chain.GenesisHash(),
+        HeadHeight:      head.Index,
+        HeadHash:        head.Hash,
+    }
+}
+
+// UpdatePeer records a peer's reported head
+func (s *SyncManager) UpdatePeer(id peer.ID, status StatusMessage) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.heads[id] = status
+}
+
+// RemovePeer forgets a peer, e.g. after it disconnects
+func (s *SyncManager) RemovePeer(id peer.ID) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.heads, id)
+}
+
+// PeerConnected seeds the peer's head from its handshake and starts a sync
+// if it is ahead of us
+func (s *SyncManager) PeerConnected(id peer.ID, status *PeerStatus) {
+    if !status.HasCapability(CapSync) {
+        return
+    }
+    hs := status.Handshake
+    s.UpdatePeer(id, StatusMessage{
+        ProtocolVersion: hs.ProtocolVersion,
+        GenesisHash:     hs.GenesisHash,
+        HeadHeight:      hs.HeadHeight,
+        HeadHash:        hs.HeadHash,
+    })
+    if hs.HeadHeight > s.chain.Height() {
+        s.Trigger()
+    }
+}
+
+func (s *SyncManager) PeerDisconnected(id peer.ID) {
+    s.RemovePeer(id)
+}
+
+// Sync runs rounds until the local chain reaches the best known peer head
+func (s *SyncManager) Sync() error {
+    s.syncing.Lock()
+    defer s.syncing.Unlock()
+
+    s.refreshPeers()
+    for {
+        local := s.chain.Height()
+        target, best := s.bestPeer()
+        if target <= local {
+            return nil
+        }
+        if target > local+maxSyncRound {
+            target = local + maxSyncRound
+        }
+        s.p2p.logger.Printf("Syncing blocks %d-%d from %d peers", local+1, target, len(s.peersAhead(local+1)))
+
+        if err := s.syncRange(best, local+1, target); err != nil {
+            return err
+        }
+    }
+}
+
+// refreshPeers asks every compatible peer for its current status
+func (s *SyncManager) refreshPeers() {
+    var wg sync.WaitGroup
+    for _, id := range s.p2p.compatiblePeers() {
+        wg.Add(1)
+        go func(id peer.ID) {
+            defer wg.Done()
+            if err := s.requestStatus(id); err != nil {
+                s.RemovePeer(id)
+            }
+        }(id)
+    }
+    wg.Wait()
+}
+
+func (s *SyncManager) requestStatus(id peer.ID) error {
+    status := s.Status()
+    resp, err := s.p2p.request(id, SyncProtocol, &status)
+    if err != nil {
+        return err
+    }
+    remote, ok := resp.(*StatusMessage)
+    if !ok {
+        return fmt.Errorf("unexpected %s response to status request", resp.Type())
+    }
+    if remote.GenesisHash != status.GenesisHash {
+        return fmt.Errorf("peer %s has a different genesis block", id)
+    }
+    s.UpdatePeer(id, *remote)
+    return nil
+}
+
+// bestPeer returns the highest known head and the peer reporting it
+func (s *SyncManager) bestPeer() (int64, peer.ID) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var (
+        best   peer.ID
+        height int64 = -1
+    )
+    for id, status := range s.heads {
+        if status.HeadHeight > height {
+            best, height = id, status.HeadHeight
+        }
+    }
+    return height, best
+}
+
+// peersAhead returns the peers whose head is at least height
+func (s *SyncManager) peersAhead(height int64) []peer.ID {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var ids []peer.ID
+    for id, status := range s.heads {
+        if status.HeadHeight >= height {
+            ids = append(ids, id)
+        }
+    }
+    return ids
+}
+
+func (s *SyncManager) syncRange(best peer.ID, from, to int64) error {
+    headers, err := s.fetchHeaders(best, from, to)
+    if err != nil {
+        s.RemovePeer(best)
+        s.reportFailure(best, err)
+        return fmt.Errorf("fetching headers from %s: %w", best, err)
+    }
+
+    s.dropStalePending(headers)
+    if err := s.downloadBodies(headers); err != nil {
+        return err
+    }
+    return s.applyPending(headers)
+}
+
+// fetchHeaders downloads headers for [from, to] and checks that they link
+// onto the local head
+func (s *SyncManager) fetchHeaders(id peer.ID, from, to int64) ([]blockchain.BlockHeader, error) {
+    prevHash := s.chain.GetBlock(from - 1).Hash
+    headers := make([]blockchain.BlockHeader, 0, to-from+1)
+
+    for start := from; start <= to; {
+        end := start + maxBlocksPerRequest - 1
+        if end > to {
+            end = to
+        }
+        resp, err := s.p2p.request(id, SyncProtocol, &SyncRequestMessage{
+            FromHeight:  start,
+            ToHeight:    end,
+            HeadersOnly: true,
+        })
+        if err != nil {
+            return nil, err
+        }
+        msg, ok := resp.(*HeadersMessage)
+        if !ok {
+            return nil, fmt.Errorf("unexpected %s response to headers request", resp.Type())
+        }
+        if len(msg.Headers) == 0 {
+            if len(headers) > 0 {
+                break
+            }
+            return nil, errors.New("peer returned no headers")
+        }
+        for _, h := range msg.Headers {
+            if start > end {
+                break
+            }
+            if h.Index != start || h.PrevHash != prevHash {
+                return nil, fmt.Errorf("%w: header %d does not extend the chain", errInvalidData, h.Index)
+            }
+            headers = append(headers, h)
+            prevHash = h.Hash
+            start++
+        }
+        if start <= end {
+            // The peer served a shorter range than advertised
+            break
+        }
+    }
+    return headers, nil
+}
+
+// dropStalePending discards downloaded bodies that no longer match the
+// headers being synced, e.g. after the best peer switched forks
+func (s *SyncManager) dropStalePending(headers []blockchain.BlockHeader) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, h := range headers {
+        if pb, exists := s.pending[h.Index]; exists && pb.block.Hash != h.Hash {
+            delete(s.pending, h.Index)
+        }
+    }
+}
+
+type bodyBatch struct {
+    headers []blockchain.BlockHeader
+}
+
+func (b bodyBatch) from() int64 { return b.headers[0].Index }
+func (b bodyBatch) to() int64   { return b.headers[len(b.headers)-1].Index }
+
+type bodyResult struct {
+    batch  bodyBatch
+    peer   peer.ID
+    blocks []*blockchain.Block
+    err    error
+}
+
+// downloadBodies fetches every body not already pending, spreading batches
+// across peers. A batch that fails is retried on another peer and the peer
+// that failed it is not used again this round.
+func (s *SyncManager) downloadBodies(headers []blockchain.BlockHeader) error {
+    var queue []bodyBatch
+    s.mu.Lock()
+    for start := 0; start < len(headers); {
+        if _, have := s.pending[headers[start].Index]; have {
+            start++
+            continue
+        }
+        end := start
+        for end < len(headers) && end-start < syncBatchSize {
+            if _, have := s.pending[headers[end].Index]; have {
+                break
+            }
+            end++
+        }
+        queue = append(queue, bodyBatch{headers: headers[start:end]})
+        start = end
+    }
+    s.mu.Unlock()
+
+    idle := s.peersAhead(headers[len(headers)-1].Index)
+    results := make(chan bodyResult)
+    inflight := 0
+
+    for len(queue) > 0 || inflight > 0 {
+        for len(queue) > 0 && len(idle) > 0 {
+            batch := queue[0]
+            queue = queue[1:]
+            id := idle[0]
+            idle = idle[1:]
+            inflight++
+            go func() {
+                blocks, err := s.fetchBodies(id, batch)
+                results <- bodyResult{batch: batch, peer: id, blocks: blocks, err: err}
+            }()
+        }
+        if inflight == 0 {
+            return errors.New("no peers left to download blocks from")
+        }
+
+        res := <-results
+        inflight--
+        if res.err != nil {
+            s.p2p.logger.Printf("Failed to fetch blocks %d-%d from %s: %v", res.batch.from(), res.batch.to(), res.peer, res.err)
+            s.RemovePeer(res.peer)
+            s.reportFailure(res.peer, res.err)
+            queue = append(queue, res.batch)
+            continue
+        }
+
+        s.mu.Lock()
+        for _, b := range res.blocks {
+            s.pending[b.Index] = pendingBlock{block: b, from: res.peer}
+        }
+        s.mu.Unlock()
+        idle = append(idle, res.peer)
+    }
+    return nil
+}
+
+// fetchBodies downloads a batch and checks each block against its header
+func (s *SyncManager) fetchBodies(id peer.ID, batch bodyBatch) ([]*blockchain.Block, error) {
+    resp, err := s.p2p.request(id, SyncProtocol, &SyncRequestMessage{
+        FromHeight: batch.from(),
+        ToHeight:   batch.to(),
+    })
+    if err != nil {
+        return nil, err
+    }
+    msg, ok := resp.(*BlocksMessage)
+    if !ok {
+        return nil, fmt.Errorf("unexpected %s response to blocks request", resp.Type())
+    }
+    if len(msg.Blocks) != len(batch.headers) {
+        return nil, fmt.Errorf("expected %d blocks, got %d", len(batch.headers), len(msg.Blocks))
+    }
+    for i, b := range msg.Blocks {
+        h := batch.headers[i]
+        if b.Header() != h || b.CalculateHash() != h.Hash {
+            return nil, fmt.Errorf("%w: block %d does not match its header", errInvalidData, h.Index)
+        }
+    }
+    return msg.Blocks, nil
+}
+
+// applyPending validates and appends downloaded blocks in height order
+func (s *SyncManager) applyPending(headers []blockchain.BlockHeader) error {
+    for _, h := range headers {
+        s.mu.Lock()
+        pb, exists := s.pending[h.Index]
+        delete(s.pending, h.Index)
+        s.mu.Unlock()
+
+        block := pb.block
+        if !exists {
+            return fmt.Errorf("block %d was not downloaded", h.Index)
+        }
+        if h.Index <= s.chain.Height() {
+            // Already received over gossip while syncing
+            continue
+        }
+        if s.validate != nil {
+            if err := s.validate(block); err != nil {
+                if errors.Is(err, ErrInvalidBlock) {
+                    s.p2p.ReportPeer(pb.from, PenaltyInvalidBlock, fmt.Sprintf("served invalid block %d", h.Index))
+                }
+                return fmt.Errorf("block %d failed validation: %w", h.Index, err)
+            }
+        }
+        if err := s.chain.AddBlock(block); err != nil {
+            return fmt.Errorf("applying block %d: %w", h.Index, err)
+        }
+    }
+    return nil
+}
+
+// reportFailure penalises a peer for a failed request, more heavily if it
+// sent data that can't be valid
+func (s *SyncManager) reportFailure(id peer.ID, err error) {
+    if errors.Is(err, errInvalidData) {
+        s.p2p.ReportPeer(id, PenaltyInvalidBlock, err.Error())
+    } else {
+        s.p2p.ReportPeer(id, PenaltyFailedRequest, err.Error())
+    }
+}
+
+// handleRequest serves status and block range requests from peers
+func (s *SyncManager) handleRequest(from peer.ID, req Payload) (Payload, error) {
+    switch msg := req.(type) {
+    case *StatusMessage:
+        s.UpdatePeer(from, *msg)
+        status := s.Status()
+        if msg.HeadHeight > status.HeadHeight {
+            s.Trigger()
+        }
+        return &status, nil
+    case *SyncRequestMessage:
+        // Check the range before capping it, since ToHeight-FromHeight
+        // overflows for extreme heights
+        if msg.FromHeight < 0 || msg.ToHeight < msg.FromHeight {
+            return nil, fmt.Errorf("invalid block range %d to %d", msg.FromHeight, msg.ToHeight)
+        }
+        to := msg.ToHeight
+        if to-msg.FromHeight >= maxBlocksPerRequest {
+            to = msg.FromHeight + maxBlocksPerRequest - 1
+        }
+        blocks := s.chain.GetBlocks(msg.FromHeight, to)
+        if msg.HeadersOnly {
+            headers := make([]blockchain.BlockHeader, len(blocks))
+            for i, b := range blocks {
+                headers[i] = b.Header()
+            }
+            return &HeadersMessage{Headers: headers}, nil
+        }
+        return &BlocksMessage{Blocks: blocks}, nil
+    default:
+        return nil, fmt.Errorf("unexpected %s request", req.Type())
+    }
+}
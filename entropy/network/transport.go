@@ -0,0 +1,146 @@
+package network
+
+import (
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/bonniegachiengu/sustena_platforms/config"
+    "github.com/bonniegachiengu/sustena_platforms/entropy/blockchain"
+    "github.com/libp2p/go-libp2p"
+    coreconnmgr "github.com/libp2p/go-libp2p/core/connmgr"
+    "github.com/libp2p/go-libp2p/core/crypto"
+    "github.com/libp2p/go-libp2p/core/host"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/p2p/net/connmgr"
+    mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+    "github.com/multiformats/go-multiaddr"
+)
+
+// Transport creates the libp2p host a node communicates through. Everything
+// above the host (handshake, gossip, sync, relay) is the same whatever the
+// transport.
+type Transport interface {
+    NewHost(identity crypto.PrivKey, networkConfig config.NetworkConfig, gater coreconnmgr.ConnectionGater) (host.Host, error)
+}
+
+// LibP2PTransport listens on networkConfig.ListenAddr with the default
+// libp2p transports. If PSKFile is set the node joins a private network:
+// every connection is encrypted with the pre-shared key, and only TCP and
+// WebSocket are available.
+type LibP2PTransport struct{}
+
+func (LibP2PTransport) NewHost(identity crypto.PrivKey, networkConfig config.NetworkConfig, gater coreconnmgr.ConnectionGater) (host.Host, error) {
+    cm, err := connmgr.NewConnManager(networkConfig.ConnLowWater, networkConfig.ConnHighWater,
+        connmgr.WithGracePeriod(time.Minute))
+    if err != nil {
+        return nil, fmt.Errorf("failed to create connection manager: %w", err)
+    }
+
+    opts := []libp2p.Option{
+        libp2p.Identity(identity),
+        libp2p.ListenAddrStrings(networkConfig.ListenAddr),
+        libp2p.ConnectionManager(cm),
+        libp2p.ConnectionGater(gater),
+    }
+    if networkConfig.PSKFile != "" {
+        if err := checkPrivateListenAddr(networkConfig.ListenAddr); err != nil {
+            return nil, err
+        }
+        psk, err := LoadPSK(networkConfig.PSKFile)
+        if err != nil {
+            return nil, err
+        }
+        opts = append(opts, libp2p.PrivateNetwork(psk))
+    }
+    return libp2p.New(opts...)
+}
+
+// MemoryTransport connects nodes in the same process through libp2p's mock
+// network, without sockets, so integration tests can run many nodes at
+// once. Every host is linked to every other; nodes still have to Connect
+// (or use Disconnect to split them again).
+//
+// The mock network has no connection gater, connection manager or
+// pre-shared key, so bans disconnect a peer but don't stop it from
+// redialing, and private network settings are ignored.
+type MemoryTransport struct {
+    mn mocknet.Mocknet
+
+    mu   sync.Mutex
+    next int
+}
+
+func NewMemoryTransport() *MemoryTransport {
+    return &MemoryTransport{mn: mocknet.New()}
+}
+
+func (t *MemoryTransport) NewHost(identity crypto.PrivKey, _ config.NetworkConfig, _ coreconnmgr.ConnectionGater) (host.Host, error) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    // The address is only a label; the mock network routes by peer ID
+    t.next++
+    addr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", t.next))
+    if err != nil {
+        return nil, err
+    }
+    h, err := t.mn.AddPeer(identity, addr)
+    if err != nil {
+        return nil, err
+    }
+    for _, id := range t.mn.Peers() {
+        if id == h.ID() {
+            continue
+        }
+        if _, err := t.mn.LinkPeers(h.ID(), id); err != nil {
+            h.Close()
+            return nil, err
+        }
+    }
+    return h, nil
+}
+
+// MemoryNetworkConfig is the configuration used by MemoryTransport.NewNode:
+// no discovery, no data directory and generous rate limits
+func MemoryNetworkConfig() config.NetworkConfig {
+    return config.NetworkConfig{
+        ChainID:       DefaultChainID,
+        PeerRateLimit: 1000,
+        PeerRateBurst: 1000,
+        TargetPeers:   defaultTargetPeers,
+    }
+}
+
+// NewNode starts a node for chain on the memory transport with
+// MemoryNetworkConfig
+func (t *MemoryTransport) NewNode(chain *blockchain.Blockchain) (*P2PNetwork, error) {
+    return NewP2PNetworkWithTransport(MemoryNetworkConfig(), chain, t)
+}
+
+// ConnectNodes connects two nodes on this transport
+func (t *MemoryTransport) ConnectNodes(a, b *P2PNetwork) error {
+    return a.host.Connect(a.ctx, peer.AddrInfo{ID: b.ID(), Addrs: b.host.Addrs()})
+}
+
+// Disconnect closes the connections between two nodes and unlinks them so
+// neither can dial the other, simulating a network partition
+func (t *MemoryTransport) Disconnect(a, b *P2PNetwork) error {
+    if err := t.mn.UnlinkPeers(a.ID(), b.ID()); err != nil {
+        return err
+    }
+    return t.mn.DisconnectPeers(a.ID(), b.ID())
+}
+
+// Reconnect heals a Disconnect and connects the nodes again
+func (t *MemoryTransport) Reconnect(a, b *P2PNetwork) error {
+    if _, err := t.mn.LinkPeers(a.ID(), b.ID()); err != nil {
+        return err
+    }
+    return t.ConnectNodes(a, b)
+}
+
+// Close shuts down every host on the transport
+func (t *MemoryTransport) Close() error {
+    return t.mn.Close()
+}
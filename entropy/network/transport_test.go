@@ -0,0 +1,90 @@
+package network
+
+import (
+    "testing"
+    "time"
+
+    "github.com/bonniegachiengu/sustena_platforms/entropy/blockchain"
+    "github.com/libp2p/go-libp2p/core/peer"
+)
+
+// waitFor polls cond until it holds or the timeout passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+    t.Helper()
+    deadline := time.Now().Add(10 * time.Second)
+    for !cond() {
+        if time.Now().After(deadline) {
+            t.Fatalf("timed out waiting for %s", what)
+        }
+        time.Sleep(20 * time.Millisecond)
+    }
+}
+
+// memoryNode is a node on a MemoryTransport with a block relay that adds
+// the blocks it receives to the node's chain
+type memoryNode struct {
+    p2p   *P2PNetwork
+    chain *blockchain.Blockchain
+    relay *BlockRelay
+}
+
+func newMemoryNode(t *testing.T, transport *MemoryTransport) *memoryNode {
+    t.Helper()
+    chain := blockchain.NewBlockchain()
+    p2p, err := transport.NewNode(chain)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { p2p.Shutdown() })
+
+    mempool := blockchain.NewMempool(chain, blockchain.DefaultMempoolSize)
+    relay := NewBlockRelay(p2p, mempool, func(_ peer.ID, block *blockchain.Block) error {
+        return chain.AddBlock(block)
+    })
+    if err := relay.Start(); err != nil {
+        t.Fatal(err)
+    }
+    return &memoryNode{p2p: p2p, chain: chain, relay: relay}
+}
+
+func TestMemoryTransportGossipsBlock(t *testing.T) {
+    transport := NewMemoryTransport()
+    defer transport.Close()
+    a := newMemoryNode(t, transport)
+    b := newMemoryNode(t, transport)
+
+    if err := transport.ConnectNodes(a.p2p, b.p2p); err != nil {
+        t.Fatal(err)
+    }
+    waitFor(t, "the handshake", func() bool {
+        return a.p2p.peerStatus(b.p2p.ID()) != nil && b.p2p.peerStatus(a.p2p.ID()) != nil
+    })
+    // Gossipsub publishes only to its mesh, which b joins at one of a's
+    // heartbeats, and drops a message it has already published. Until b
+    // has a block, keep publishing new candidates for height 1. b's
+    // mempool has none of their transactions, so it has to fetch them
+    // from a to rebuild the compact block.
+    genesis := a.chain.GetLastBlock()
+    published := make(map[string]blockchain.Transaction)
+    var lastPublish time.Time
+    waitFor(t, "b to apply a block", func() bool {
+        if b.chain.Height() == 1 {
+            return true
+        }
+        if time.Since(lastPublish) >= 100*time.Millisecond {
+            lastPublish = time.Now()
+            tx := blockchain.Transaction{From: "alice", To: "bob", Amount: 10, Nonce: int64(len(published))}
+            block := blockchain.NewBlock(1, []blockchain.Transaction{tx}, genesis.Hash, "validator", 1)
+            published[block.Hash] = tx
+            if err := a.relay.PublishBlock(block); err != nil {
+                t.Fatal(err)
+            }
+        }
+        return false
+    })
+    got := b.chain.GetLastBlock()
+    tx, ok := published[got.Hash]
+    if !ok || !b.chain.HasTransaction(tx.Hash()) {
+        t.Fatalf("b applied block %s, which a didn't publish with its transaction", got.Hash)
+    }
+}
@@ -0,0 +1,319 @@
+package network
+
+import (
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/bonniegachiengu/sustena_platforms/entropy/blockchain"
+    lru "github.com/hashicorp/golang-lru/v2"
+    "github.com/libp2p/go-libp2p/core/peer"
+    "github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// TxProtocol is the request/response protocol used to fetch transaction
+// bodies after an announcement
+const TxProtocol = protocol.ID("/sustena/tx/1.0.0")
+
+const (
+    // maxAnnounceHashes caps the hashes in one announcement or request
+    maxAnnounceHashes = 256
+    // seenCacheSize is the number of transaction hashes remembered
+    seenCacheSize = 1 << 16
+    // announceInterval batches local announcements
+    announceInterval = 200 * time.Millisecond
+    // fetchQueueSize is the number of pending body requests; announcements
+    // arriving while it is full are dropped
+    fetchQueueSize = 256
+    // maxConcurrentFetches caps the body requests in flight at once
+    maxConcurrentFetches = 16
+    // maxPeerFetches caps the requests in flight to one peer, so a slow
+    // peer can't take every slot; its further announcements are dropped
+    // and the transactions fetched from whoever announces them next
+    maxPeerFetches = 2
+)
+
+// TxValidator checks a transaction beyond blockchain.CheckTransaction
+// before it enters the mempool and is relayed
+type TxValidator func(tx blockchain.Transaction) error
+
+type fetchJob struct {
+    from   peer.ID
+    hashes []string
+}
+
+// TxRelay propagates transactions between mempools. Peers announce the
+// hashes of transactions they hold; the receiver requests only the bodies
+// it hasn't seen, validates them, and announces them onwards. Transactions
+// already seen, pooled or included in a block are never fetched or
+// re-announced.
+type TxRelay struct {
+    p2p      *P2PNetwork
+    mempool  *blockchain.Mempool
+    validate TxValidator
+
+    // seen holds every hash processed, valid or not
+    seen *lru.Cache[string, struct{}]
+
+    mu       sync.Mutex
+    inflight map[string]struct{}
+    // peerFetches counts the requests in flight to each peer
+    peerFetches map[peer.ID]int
+    outbox      []string
+
+    fetches chan fetchJob
+}
+
+func NewTxRelay(p2p *P2PNetwork, mempool *blockchain.Mempool, validate TxValidator) *TxRelay {
+    seen, _ := lru.New[string, struct{}](seenCacheSize)
+    r := &TxRelay{
+        p2p:         p2p,
+        mempool:     mempool,
+        validate:    validate,
+        seen:        seen,
+        inflight:    make(map[string]struct{}),
+        peerFetches: make(map[peer.ID]int),
+        fetches:     make(chan fetchJob, fetchQueueSize),
+    }
+    p2p.serve(TxProtocol, r.handleRequest)
+    p2p.SetGossipHook(TopicTransactions, r.handleGossip)
+    return r
+}
+
+// Start subscribes to the transaction topic and runs the fetch and
+// announce loops until the network shuts down
+func (r *TxRelay) Start() error {
+    // Gossip is only received on subscribed topics. handleGossip consumes
+    // every message, so nothing is ever delivered on the channel.
+    sub, err := r.p2p.Subscribe(TopicTransactions)
+    if err != nil {
+        return err
+    }
+    go func() {
+        for range sub {
+        }
+    }()
+
+    go r.fetchLoop()
+    go r.announceLoop()
+    return nil
+}
+
+// Submit adds a locally created transaction to the mempool and announces it
+func (r *TxRelay) Submit(tx blockchain.Transaction) error {
+    return r.accept(tx)
+}
+
+// accept validates tx, adds it to the mempool and queues its announcement
+func (r *TxRelay) accept(tx blockchain.Transaction) error {
+    hash := tx.Hash()
+    r.seen.Add(hash, struct{}{})
+
+    if r.validate != nil {
+        if err := r.validate(tx); err != nil {
+            return fmt.Errorf("%w: %v", blockchain.ErrInvalidTx, err)
+        }
+    }
+    if err := r.mempool.Add(tx); err != nil {
+        return err
+    }
+
+    r.mu.Lock()
+    r.outbox = append(r.outbox, hash)
+    r.mu.Unlock()
+    return nil
+}
+
+// known reports whether a hash needs no fetching
+func (r *TxRelay) known(hash string) bool {
+    if r.seen.Contains(hash) || r.mempool.Has(hash) || r.p2p.chain.HasTransaction(hash) {
+        return true
+    }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    _, fetching := r.inflight[hash]
+    return fetching
+}
+
+// handleGossip consumes every message on the transaction topic; valid
+// transactions are re-announced by accept rather than forwarded as-is
+func (r *TxRelay) handleGossip(from peer.ID, msg Payload) bool {
+    switch m := msg.(type) {
+    case *TxAnnounceMessage:
+        var wanted []string
+        for _, hash := range m.Hashes {
+            if len(wanted) == maxAnnounceHashes {
+                break
+            }
+            if !r.known(hash) {
+                wanted = append(wanted, hash)
+            }
+        }
+        if len(wanted) == 0 {
+            return false
+        }
+
+        r.mu.Lock()
+        for _, hash := range wanted {
+            r.inflight[hash] = struct{}{}
+        }
+        r.mu.Unlock()
+
+        select {
+        case r.fetches <- fetchJob{from: from, hashes: wanted}:
+        default:
+            r.finishFetch(wanted)
+        }
+    case *TransactionMessage:
+        if !r.known(m.Transaction.Hash()) {
+            r.receive(from, m.Transaction)
+        }
+    }
+    return false
+}
+
+func (r *TxRelay) finishFetch(hashes []string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for _, hash := range hashes {
+        delete(r.inflight, hash)
+    }
+}
+
+// fetchLoop runs queued fetches concurrently, up to maxConcurrentFetches
+// at once and maxPeerFetches per peer
+func (r *TxRelay) fetchLoop() {
+    slots := make(chan struct{}, maxConcurrentFetches)
+    for {
+        var job fetchJob
+        select {
+        case <-r.p2p.ctx.Done():
+            return
+        case job = <-r.fetches:
+        }
+        if !r.startPeerFetch(job.from) {
+            r.finishFetch(job.hashes)
+            continue
+        }
+        select {
+        case <-r.p2p.ctx.Done():
+            return
+        case slots <- struct{}{}:
+        }
+        go func() {
+            defer func() { <-slots }()
+            defer r.endPeerFetch(job.from)
+            r.fetch(job)
+        }()
+    }
+}
+
+// startPeerFetch reserves one of a peer's fetches, if it has any left
+func (r *TxRelay) startPeerFetch(id peer.ID) bool {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.peerFetches[id] >= maxPeerFetches {
+        return false
+    }
+    r.peerFetches[id]++
+    return true
+}
+
+func (r *TxRelay) endPeerFetch(id peer.ID) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.peerFetches[id]--; r.peerFetches[id] == 0 {
+        delete(r.peerFetches, id)
+    }
+}
+
+// fetch requests announced bodies from the announcing peer
+func (r *TxRelay) fetch(job fetchJob) {
+    defer r.finishFetch(job.hashes)
+
+    resp, err := r.p2p.request(job.from, TxProtocol, &GetTxsMessage{Hashes: job.hashes})
+    if err != nil {
+        r.p2p.ReportPeer(job.from, PenaltyFailedRequest, fmt.Sprintf("transaction request failed: %v", err))
+        return
+    }
+    msg, ok := resp.(*TransactionsMessage)
+    if !ok {
+        r.p2p.ReportPeer(job.from, PenaltyInvalidMsg, fmt.Sprintf("unexpected %s response to transaction request", resp.Type()))
+        return
+    }
+
+    requested := make(map[string]bool, len(job.hashes))
+    for _, hash := range job.hashes {
+        requested[hash] = true
+    }
+    for _, tx := range msg.Transactions {
+        hash := tx.Hash()
+        if !requested[hash] {
+            r.p2p.ReportPeer(job.from, PenaltyInvalidMsg, "sent an unrequested transaction")
+            continue
+        }
+        requested[hash] = false
+        r.receive(job.from, tx)
+    }
+}
+
+// receive accepts a transaction from a peer and scores the peer for it
+func (r *TxRelay) receive(from peer.ID, tx blockchain.Transaction) {
+    err := r.accept(tx)
+    switch {
+    case err == nil:
+        r.p2p.ReportPeer(from, RewardUsefulMessage, "new transaction")
+    case errors.Is(err, blockchain.ErrInvalidTx):
+        r.p2p.ReportPeer(from, PenaltyInvalidMsg, err.Error())
+    }
+}
+
+// announceLoop periodically announces newly accepted transactions
+func (r *TxRelay) announceLoop() {
+    ticker := time.NewTicker(announceInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-r.p2p.ctx.Done():
+            return
+        case <-ticker.C:
+        }
+
+        r.mu.Lock()
+        pending := r.outbox
+        r.outbox = nil
+        r.mu.Unlock()
+
+        for len(pending) > 0 {
+            n := len(pending)
+            if n > maxAnnounceHashes {
+                n = maxAnnounceHashes
+            }
+            if err := r.p2p.Publish(&TxAnnounceMessage{Hashes: pending[:n]}); err != nil {
+                r.p2p.logger.Printf("Failed to announce transactions: %v", err)
+            }
+            pending = pending[n:]
+        }
+    }
+}
+
+// handleRequest serves transaction bodies from the mempool
+func (r *TxRelay) handleRequest(from peer.ID, req Payload) (Payload, error) {
+    msg, ok := req.(*GetTxsMessage)
+    if !ok {
+        return nil, fmt.Errorf("unexpected %s request", req.Type())
+    }
+    if len(msg.Hashes) > maxAnnounceHashes {
+        return nil, fmt.Errorf("requested %d transactions, limit is %d", len(msg.Hashes), maxAnnounceHashes)
+    }
+
+    resp := &TransactionsMessage{}
+    for _, hash := range msg.Hashes {
+        if tx, exists := r.mempool.Get(hash); exists {
+            resp.Transactions = append(resp.Transactions, tx)
+        }
+    }
+    return resp, nil
+}
@@ -0,0 +1,134 @@
+package state
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/bonniegachiengu/sustena_platforms/entropy/blockchain"
+	"github.com/bonniegachiengu/sustena_platforms/symmetry/vm"
+)
+
+// DefaultTxGasLimit is the gas each transaction's contract call gets
+const DefaultTxGasLimit = 1000000
+
+// Receipt is the outcome of one transaction of a block
+type Receipt struct {
+	TxHash  string
+	GasUsed uint64
+	Result  interface{}
+	Logs    []vm.Log
+	Err     error
+}
+
+// Executor runs the contracts called by a block's transactions.
+//
+// The chain's balances already count a block's transfers once it has been
+// added, so run blocks after adding them: the executor doesn't move the
+// transactions' amounts again, it only calls the contract at each
+// transaction's recipient, with the amount as the call's value. A
+// transaction to an address without code has nothing to run. Balances
+// come from the chain, so the chain mustn't grow while a block runs.
+type Executor struct {
+	state    *State
+	workers  int
+	gasLimit uint64
+}
+
+// NewExecutor returns an executor that runs transactions on up to workers
+// goroutines at once, or one per CPU if workers isn't positive
+func NewExecutor(state *State, workers int) *Executor {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &Executor{state: state, workers: workers, gasLimit: DefaultTxGasLimit}
+}
+
+// SetGasLimit sets the gas each transaction's contract call gets
+func (e *Executor) SetGasLimit(gas uint64) {
+	e.gasLimit = gas
+}
+
+// ExecuteSerial runs the block's transactions one after another
+func (e *Executor) ExecuteSerial(block *blockchain.Block) []Receipt {
+	receipts := make([]Receipt, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		view := newTxView(e.state)
+		receipts[i] = e.apply(view, block, tx)
+		view.apply()
+	}
+	return receipts
+}
+
+// Execute runs the block's transactions in parallel and leaves the state
+// and receipts exactly as ExecuteSerial would.
+//
+// Every transaction first runs on its own view of the state as it was
+// before the block. The views are then applied in block order; a
+// transaction that read something an earlier one wrote saw stale state,
+// so it is run again against the state as it is by then, with every
+// earlier transaction applied.
+func (e *Executor) Execute(block *blockchain.Block) []Receipt {
+	txs := block.Transactions
+	views := make([]*txView, len(txs))
+	receipts := make([]Receipt, len(txs))
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < e.workers && w < len(txs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				views[i] = newTxView(e.state)
+				receipts[i] = e.apply(views[i], block, txs[i])
+			}
+		}()
+	}
+	for i := range txs {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	written := make(map[stateKey]struct{})
+	for i, view := range views {
+		if conflicts(view, written) {
+			view = newTxView(e.state)
+			receipts[i] = e.apply(view, block, txs[i])
+		}
+		view.apply()
+		view.writes(func(k stateKey) { written[k] = struct{}{} })
+	}
+	return receipts
+}
+
+// conflicts reports whether the view read any of written
+func conflicts(view *txView, written map[stateKey]struct{}) bool {
+	for k := range view.reads {
+		if _, ok := written[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// apply runs one transaction on view. A failed call leaves no changes.
+func (e *Executor) apply(view *txView, block *blockchain.Block, tx blockchain.Transaction) Receipt {
+	receipt := Receipt{TxHash: tx.Hash()}
+	code, exists := view.Code(tx.To)
+	if !exists {
+		return receipt
+	}
+
+	checkpoint := view.Checkpoint()
+	host := NewHost(view, block, tx.From, tx.To)
+	call := vm.Call{Address: tx.To, Value: tx.Amount, Gas: e.gasLimit}
+	receipt.Result, receipt.GasUsed, receipt.Err = vm.Execute(host, code, call)
+	if receipt.Err != nil {
+		view.RevertTo(checkpoint)
+	} else {
+		view.Commit(checkpoint)
+	}
+	receipt.Logs = append([]vm.Log(nil), view.logs...)
+	return receipt
+}
@@ -0,0 +1,129 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/bonniegachiengu/sustena_platforms/entropy/blockchain"
+	"github.com/bonniegachiengu/sustena_platforms/symmetry/asm"
+)
+
+// counter increments one storage key on every call, so every call
+// conflicts with the one before
+const counter = `
+	PUSH "count"
+	PUSH "count"
+	SLOAD
+	PUSH 1
+	ADD
+	SSTORE
+	PUSH "count"
+	PUSH "count"
+	SLOAD
+	LOG
+	PUSH "count"
+	SLOAD
+	RETURN
+`
+
+// register writes a key per caller, so calls from different callers don't
+// conflict
+const register = `
+	CALLER
+	NUMBER
+	SSTORE
+`
+
+// payout pays its caller until its balance runs out, so whether a call
+// succeeds depends on the calls before it
+const payout = `
+	CALLER
+	PUSH 5
+	TRANSFER
+	ADDRESS
+	BALANCE
+	RETURN
+`
+
+// setupBlock deploys the contracts, funds payout and adds a block of
+// transactions calling them to a new chain
+func setupBlock(t *testing.T) (*State, *blockchain.Block) {
+	t.Helper()
+	chain := blockchain.NewBlockchain()
+	state := NewState(chain)
+
+	var contracts []string
+	for _, src := range []string{counter, register, payout} {
+		code, err := asm.Assemble(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		address, err := state.Deploy(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contracts = append(contracts, address)
+	}
+
+	txs := []blockchain.Transaction{{From: "faucet", To: contracts[2], Amount: 20}}
+	for i := 0; i < 40; i++ {
+		to := contracts[i%3]
+		if i%7 == 0 {
+			to = "nobody"
+		}
+		txs = append(txs, blockchain.Transaction{
+			From:   fmt.Sprintf("user%d", i%5),
+			To:     to,
+			Amount: int64(i % 2),
+			Nonce:  int64(i),
+		})
+	}
+	last := chain.GetLastBlock()
+	block := blockchain.NewBlock(last.Index+1, txs, last.Hash, "validator", 1)
+	if err := chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	return state, block
+}
+
+func TestExecuteMatchesSerial(t *testing.T) {
+	serialState, serialBlock := setupBlock(t)
+	parallelState, parallelBlock := setupBlock(t)
+
+	serial := NewExecutor(serialState, 1).ExecuteSerial(serialBlock)
+	parallel := NewExecutor(parallelState, 8).Execute(parallelBlock)
+
+	if !reflect.DeepEqual(parallel, serial) {
+		t.Fatalf("receipts differ:\nparallel %v\nserial   %v", parallel, serial)
+	}
+	if !reflect.DeepEqual(parallelState.balances, serialState.balances) {
+		t.Errorf("balances differ:\nparallel %v\nserial   %v", parallelState.balances, serialState.balances)
+	}
+	if !reflect.DeepEqual(parallelState.storage, serialState.storage) {
+		t.Errorf("storage differs:\nparallel %v\nserial   %v", parallelState.storage, serialState.storage)
+	}
+	if !reflect.DeepEqual(parallelState.Logs(), serialState.Logs()) {
+		t.Errorf("logs differ:\nparallel %v\nserial   %v", parallelState.Logs(), serialState.Logs())
+	}
+
+	// The block only tests conflicts if payouts both succeed and fail
+	var paid, refused int
+	for i, receipt := range serial {
+		if i%3 != 0 || (i-1)%7 == 0 {
+			continue
+		}
+		if errors.Is(receipt.Err, ErrInsufficientBalance) {
+			refused++
+		} else if receipt.Err == nil {
+			paid++
+		}
+	}
+	if paid == 0 || refused == 0 {
+		t.Errorf("got %d payouts and %d refusals, want some of each", paid, refused)
+	}
+	if logs := len(serialState.Logs()); logs != 12 {
+		t.Errorf("got %d counter logs, want 12", logs)
+	}
+}
@@ -0,0 +1,117 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/bonniegachiengu/sustena_platforms/entropy/blockchain"
+	"github.com/bonniegachiengu/sustena_platforms/symmetry/vm"
+)
+
+var _ vm.Host = (*Host)(nil)
+
+// StateDB is the state a Host works on: a State, or a transaction's view
+// of one during parallel execution
+type StateDB interface {
+	Code(address string) ([]byte, bool)
+	ReentrancyPolicy(address string) vm.ReentrancyPolicy
+	Balance(address string) int64
+	Transfer(from, to string, amount int64) error
+	Storage(address, key string) (interface{}, bool)
+	SetStorage(address, key string, value interface{})
+	AddLog(log vm.Log)
+
+	Checkpoint() int
+	RevertTo(checkpoint int)
+	Commit(checkpoint int)
+}
+
+var _ StateDB = (*State)(nil)
+
+// Host gives one contract call access to the state. Nested calls get
+// their own Host, with the calling contract as caller.
+type Host struct {
+	state   StateDB
+	caller  string
+	address string
+
+	blockNumber int64
+	timestamp   int64
+	running     []string // contracts on the call stack, outermost first
+}
+
+// NewHost returns the host for a call from caller to the contract at
+// address, executed as part of block
+func NewHost(state StateDB, block *blockchain.Block, caller, address string) *Host {
+	return &Host{
+		state:       state,
+		caller:      caller,
+		address:     address,
+		blockNumber: block.Index,
+		timestamp:   block.Timestamp,
+		running:     []string{address},
+	}
+}
+
+func (h *Host) Caller() string     { return h.caller }
+func (h *Host) Address() string    { return h.address }
+func (h *Host) BlockNumber() int64 { return h.blockNumber }
+func (h *Host) Timestamp() int64   { return h.timestamp }
+
+func (h *Host) GetBalance(address string) int64 {
+	return h.state.Balance(address)
+}
+
+func (h *Host) Transfer(to string, amount int64) error {
+	return h.state.Transfer(h.address, to, amount)
+}
+
+func (h *Host) GetStorage(key string) (interface{}, bool) {
+	return h.state.Storage(h.address, key)
+}
+
+func (h *Host) SetStorage(key string, value interface{}) {
+	h.state.SetStorage(h.address, key, value)
+}
+
+func (h *Host) EmitLog(topic string, data interface{}) {
+	h.state.AddLog(vm.Log{Address: h.address, Topic: topic, Data: data})
+}
+
+func (h *Host) Checkpoint() int         { return h.state.Checkpoint() }
+func (h *Host) RevertTo(checkpoint int) { h.state.RevertTo(checkpoint) }
+func (h *Host) Commit(checkpoint int)   { h.state.Commit(checkpoint) }
+
+func (h *Host) CallContract(call vm.Call) (interface{}, uint64, error) {
+	code, exists := h.state.Code(call.Address)
+	if !exists {
+		return nil, 0, fmt.Errorf("%w %s", ErrNoContract, call.Address)
+	}
+	if len(h.running) > vm.MaxCallDepth {
+		return nil, 0, vm.ErrCallDepth
+	}
+	for _, address := range h.running {
+		if address == call.Address && !h.state.ReentrancyPolicy(address).Allows(call.Static) {
+			return nil, 0, fmt.Errorf("%w into %s", vm.ErrReentrancy, address)
+		}
+	}
+
+	callee := *h
+	callee.caller = h.address
+	callee.address = call.Address
+	callee.running = append(h.running[:len(h.running):len(h.running)], call.Address)
+
+	checkpoint := h.state.Checkpoint()
+	if call.Value > 0 {
+		if err := h.state.Transfer(h.address, call.Address, call.Value); err != nil {
+			h.state.RevertTo(checkpoint)
+			return nil, 0, err
+		}
+	}
+	result, used, err := vm.Execute(&callee, code, call)
+	if err != nil {
+		h.state.RevertTo(checkpoint)
+	} else {
+		h.state.Commit(checkpoint)
+	}
+	return result, used, err
+}
@@ -0,0 +1,187 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bonniegachiengu/sustena_platforms/entropy/blockchain"
+	"github.com/bonniegachiengu/sustena_platforms/symmetry/vm"
+)
+
+var (
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrNoContract          = errors.New("no contract at address")
+)
+
+// State is the account and contract state contracts run against. Balances
+// start from what the chain's transactions give each address; transfers
+// made by contracts are kept on top of that, together with contract code,
+// storage and emitted logs. Every change is journaled so that a failed
+// call can be rolled back.
+type State struct {
+	chain *blockchain.Blockchain
+
+	balances map[string]int64 // changes made by contract transfers
+	code     map[string][]byte
+	policies map[string]vm.ReentrancyPolicy
+	storage  map[string]map[string]interface{}
+	logs     []vm.Log
+	journal  *vm.Journal
+	mu       sync.RWMutex
+}
+
+func NewState(chain *blockchain.Blockchain) *State {
+	return &State{
+		chain:    chain,
+		balances: make(map[string]int64),
+		code:     make(map[string][]byte),
+		policies: make(map[string]vm.ReentrancyPolicy),
+		storage:  make(map[string]map[string]interface{}),
+		journal:  vm.NewJournal(),
+	}
+}
+
+// Deploy registers code with the chain and stores it at the returned
+// address
+func (s *State) Deploy(code []byte) (string, error) {
+	address, err := s.chain.DeployContract(code)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, existed := s.code[address]
+	s.code[address] = append([]byte(nil), code...)
+	if !existed {
+		s.journal.Record(func() { delete(s.code, address) })
+	}
+	return address, nil
+}
+
+// Code returns the bytecode of the contract at address
+func (s *State) Code(address string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	code, exists := s.code[address]
+	return code, exists
+}
+
+// SetReentrancyPolicy sets whether the contract at address can be called
+// while it is already running. Contracts allow reentry by default.
+func (s *State) SetReentrancyPolicy(address string, policy vm.ReentrancyPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.policies[address] = policy
+}
+
+func (s *State) ReentrancyPolicy(address string) vm.ReentrancyPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.policies[address]
+}
+
+func (s *State) Balance(address string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.chain.GetBalance(address) + s.balances[address]
+}
+
+// Transfer moves amount from one address to another
+func (s *State) Transfer(from, to string, amount int64) error {
+	if amount < 0 {
+		return fmt.Errorf("negative amount %d", amount)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.chain.GetBalance(from)+s.balances[from] < amount {
+		return fmt.Errorf("%w: %s", ErrInsufficientBalance, from)
+	}
+	s.balances[from] -= amount
+	s.balances[to] += amount
+	s.journal.Record(func() {
+		s.balances[from] += amount
+		s.balances[to] -= amount
+	})
+	return nil
+}
+
+// Storage reads a key of a contract's storage
+func (s *State) Storage(address, key string) (interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, exists := s.storage[address][key]
+	return value, exists
+}
+
+// SetStorage writes a key of a contract's storage
+func (s *State) SetStorage(address, key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	storage := s.storage[address]
+	if storage == nil {
+		storage = make(map[string]interface{})
+		s.storage[address] = storage
+	}
+	previous, existed := storage[key]
+	s.journal.Record(func() {
+		if existed {
+			storage[key] = previous
+		} else {
+			delete(storage, key)
+		}
+	})
+	storage[key] = value
+}
+
+func (s *State) AddLog(log vm.Log) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.logs)
+	s.logs = append(s.logs, log)
+	s.journal.Record(func() { s.logs = s.logs[:n] })
+}
+
+// Logs returns the logs emitted so far, oldest first
+func (s *State) Logs() []vm.Log {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]vm.Log(nil), s.logs...)
+}
+
+// Checkpoint marks the current state and returns an id for RevertTo or
+// Commit. Checkpoints nest, one per contract call.
+func (s *State) Checkpoint() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.journal.Checkpoint()
+}
+
+// RevertTo undoes every change made since checkpoint
+func (s *State) RevertTo(checkpoint int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.journal.RevertTo(checkpoint)
+}
+
+// Commit keeps the changes made since checkpoint
+func (s *State) Commit(checkpoint int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.journal.Commit(checkpoint)
+}
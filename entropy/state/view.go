@@ -0,0 +1,150 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/bonniegachiengu/sustena_platforms/symmetry/vm"
+)
+
+var _ StateDB = (*txView)(nil)
+
+// stateKey names a piece of state a transaction can read or write: an
+// account balance, or a key of a contract's storage
+type stateKey struct {
+	storage bool
+	address string
+	key     string
+}
+
+func balanceKey(address string) stateKey {
+	return stateKey{address: address}
+}
+
+func storageKey(address, key string) stateKey {
+	return stateKey{storage: true, address: address, key: key}
+}
+
+// txView is one transaction's view of a State. Writes stay in the view
+// until apply copies them to the State, and every value read from the
+// State is recorded so that the executor can tell whether an earlier
+// transaction changed it. Contract code and reentrancy policies don't
+// change while a block runs, so reading them isn't tracked.
+type txView struct {
+	base *State
+
+	// balances holds the change made to each balance. Changes add up in
+	// any order, so crediting an address doesn't read its balance.
+	balances map[string]int64
+	storage  map[stateKey]interface{}
+	logs     []vm.Log
+	journal  *vm.Journal
+
+	reads map[stateKey]struct{}
+}
+
+func newTxView(base *State) *txView {
+	return &txView{
+		base:     base,
+		balances: make(map[string]int64),
+		storage:  make(map[stateKey]interface{}),
+		journal:  vm.NewJournal(),
+		reads:    make(map[stateKey]struct{}),
+	}
+}
+
+func (v *txView) Code(address string) ([]byte, bool) {
+	return v.base.Code(address)
+}
+
+func (v *txView) ReentrancyPolicy(address string) vm.ReentrancyPolicy {
+	return v.base.ReentrancyPolicy(address)
+}
+
+func (v *txView) Balance(address string) int64 {
+	v.reads[balanceKey(address)] = struct{}{}
+	return v.base.Balance(address) + v.balances[address]
+}
+
+func (v *txView) Transfer(from, to string, amount int64) error {
+	if amount < 0 {
+		return fmt.Errorf("negative amount %d", amount)
+	}
+	if v.Balance(from) < amount {
+		return fmt.Errorf("%w: %s", ErrInsufficientBalance, from)
+	}
+	v.adjust(from, -amount)
+	v.adjust(to, amount)
+	return nil
+}
+
+func (v *txView) adjust(address string, delta int64) {
+	previous, existed := v.balances[address]
+	v.journal.Record(func() {
+		if existed {
+			v.balances[address] = previous
+		} else {
+			delete(v.balances, address)
+		}
+	})
+	v.balances[address] = previous + delta
+}
+
+func (v *txView) Storage(address, key string) (interface{}, bool) {
+	k := storageKey(address, key)
+	if value, written := v.storage[k]; written {
+		return value, true
+	}
+	v.reads[k] = struct{}{}
+	return v.base.Storage(address, key)
+}
+
+func (v *txView) SetStorage(address, key string, value interface{}) {
+	k := storageKey(address, key)
+	previous, existed := v.storage[k]
+	v.journal.Record(func() {
+		if existed {
+			v.storage[k] = previous
+		} else {
+			delete(v.storage, k)
+		}
+	})
+	v.storage[k] = value
+}
+
+func (v *txView) AddLog(log vm.Log) {
+	n := len(v.logs)
+	v.logs = append(v.logs, log)
+	v.journal.Record(func() { v.logs = v.logs[:n] })
+}
+
+func (v *txView) Checkpoint() int         { return v.journal.Checkpoint() }
+func (v *txView) RevertTo(checkpoint int) { v.journal.RevertTo(checkpoint) }
+func (v *txView) Commit(checkpoint int)   { v.journal.Commit(checkpoint) }
+
+// writes calls fn with each piece of state the view changed
+func (v *txView) writes(fn func(stateKey)) {
+	for address := range v.balances {
+		fn(balanceKey(address))
+	}
+	for k := range v.storage {
+		fn(k)
+	}
+}
+
+// apply copies the view's changes to the State
+func (v *txView) apply() {
+	s := v.base
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for address, delta := range v.balances {
+		s.balances[address] += delta
+	}
+	for k, value := range v.storage {
+		if s.storage[k.address] == nil {
+			s.storage[k.address] = make(map[string]interface{})
+		}
+		s.storage[k.address][k.key] = value
+	}
+	s.logs = append(s.logs, v.logs...)
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"time"
 
 	"github.com/bonniegachiengu/sustena_platforms/entropy/blockchain"
 	"github.com/bonniegachiengu/sustena_platforms/entropy/consensus"
@@ -14,9 +17,17 @@ import (
 	"github.com/bonniegachiengu/sustena_platforms/api"
 	"github.com/bonniegachiengu/sustena_platforms/utils"
 	"github.com/bonniegachiengu/sustena_platforms/config"
+	"github.com/libp2p/go-libp2p/core/peer"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	fmt.Println("Starting Sustena Platform")
 	// Initialize configuration
 	cfg, err := config.LoadConfig()
@@ -31,19 +42,12 @@ func main() {
 	pos := consensus.NewProofOfStake()
 
 	// Initialize P2P network
-	p2p, err := network.NewP2PNetwork(cfg.NetworkConfig.ListenAddr)
+	p2p, err := network.NewP2PNetwork(cfg.NetworkConfig.ListenAddr, bc)
 	if err != nil {
 		log.Fatalf("Failed to initialize P2P network: %v", err)
 	}
 	defer p2p.Shutdown()
 
-	// Connect to bootstrap peers
-	for _, peerAddr := range cfg.NetworkConfig.BootstrapPeers {
-		if err := p2p.Connect(peerAddr); err != nil {
-			log.Printf("Failed to connect to peer %s: %v", peerAddr, err)
-		}
-	}
-
 	// Initialize Symmetry components
 	interpreter := interpreter.NewInterpreter()
 	vm := vm.NewVM()
@@ -57,31 +61,69 @@ func main() {
 
 	// Initialize API server
 	apiServer := api.NewServer(api.APIConfig{
-		// Map the fields from cfg.APIConfig to api.APIConfig
-		// For example:
-		// Port: cfg.APIConfig.Port,
-		// Host: cfg.APIConfig.Host,
-		// ... other fields ...
-	})
+		Port: cfg.APIConfig.Port,
+		Host: cfg.APIConfig.Host,
+	}, p2p)
 	go apiServer.Start()
 	defer apiServer.Shutdown()
 
-	// Start the main application loop
-	for {
-		// Handle incoming P2P messages
-		msg := p2p.ReceiveMessage()
-		if msg != nil {
-			handleMessage(msg, bc, pos, interpreter, vm, compiler, parser)
+	// Catch up with peers that are ahead of us
+	syncer := network.NewSyncManager(p2p, validateBlock(pos))
+	syncer.Start()
+
+	// Relay transactions between mempools
+	mempool := blockchain.NewMempool(bc, blockchain.DefaultMempoolSize)
+	txRelay := network.NewTxRelay(p2p, mempool, nil)
+	if err := txRelay.Start(); err != nil {
+		log.Fatalf("Failed to start transaction relay: %v", err)
+	}
+
+	// Relay blocks as compact blocks rebuilt from the mempool
+	handleBlock := blockHandler(p2p, bc, pos, syncer)
+	blockRelay := network.NewBlockRelay(p2p, mempool, handleBlock)
+	if err := blockRelay.Start(); err != nil {
+		log.Fatalf("Failed to start block relay: %v", err)
+	}
+
+	// Route decoded network messages to their handlers
+	dispatcher := network.NewDispatcher(p2p.ChainID())
+	registerHandlers(dispatcher, handleBlock)
+
+	// Subscribe to gossip topics
+	messages := make(chan *network.Message)
+	for _, topic := range network.Topics {
+		sub, err := p2p.Subscribe(topic)
+		if err != nil {
+			log.Fatalf("Failed to subscribe to %s: %v", topic, err)
 		}
+		go func() {
+			for msg := range sub {
+				messages <- msg
+			}
+		}()
+	}
 
-		// Process pending transactions
-		processPendingTransactions(bc, pos)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
-		// Run Symmetry scripts
-		runSymmetryScripts(interpreter, vm)
+	// Start the main application loop
+	for {
+		select {
+		case msg := <-messages:
+			// Handle incoming P2P messages
+			if err := dispatcher.Deliver(msg.ReceivedFrom, msg.Payload); err != nil {
+				log.Printf("Dropping message from %s: %v", msg.ReceivedFrom, err)
+			}
+		case <-ticker.C:
+			// Process pending transactions
+			processPendingTransactions(bc, pos)
 
-		// Compile and deploy Embroidery contracts
-		compileAndDeployContracts(compiler, parser, bc)
+			// Run Symmetry scripts
+			runSymmetryScripts(interpreter, vm)
+
+			// Compile and deploy Embroidery contracts
+			compileAndDeployContracts(compiler, parser, bc)
+		}
 
 		// Check for exit condition
 		if shouldExit() {
@@ -97,10 +139,55 @@ func main() {
 	fmt.Println("Sustena Platform shutdown complete")
 }
 
-func handleMessage(msg []byte, bc *blockchain.Blockchain, pos *consensus.ProofOfStake, 
-	interpreter *interpreter.Interpreter, vm *vm.VM, compiler *compiler.Compiler, parser *parser.Parser) {
-	// Handle different types of messages
-	// This is a placeholder and should be implemented based on your message types
+// validateBlock checks a block received from the network before it is
+// added. Only a bad hash marks the sender as misbehaving; a consensus
+// rejection may just mean the local validator set is incomplete.
+func validateBlock(pos *consensus.ProofOfStake) network.BlockValidator {
+	return func(block *blockchain.Block) error {
+		if block.Hash != block.CalculateHash() {
+			return fmt.Errorf("%w: block %d has an invalid hash", network.ErrInvalidBlock, block.Index)
+		}
+		if !pos.Validate(block) {
+			return fmt.Errorf("block %d failed consensus validation", block.Index)
+		}
+		return nil
+	}
+}
+
+// blockHandler applies blocks received from peers, syncing first if the
+// block is ahead of the local chain
+func blockHandler(p2p *network.P2PNetwork, bc *blockchain.Blockchain, pos *consensus.ProofOfStake,
+	syncer *network.SyncManager) network.BlockHandler {
+	validate := validateBlock(pos)
+	return func(from peer.ID, block *blockchain.Block) error {
+		if block.Index > bc.Height()+1 {
+			// We are behind; fetch the missing blocks first
+			syncer.Trigger()
+			return nil
+		}
+		if err := validate(block); err != nil {
+			if errors.Is(err, network.ErrInvalidBlock) {
+				p2p.ReportPeer(from, network.PenaltyInvalidBlock, err.Error())
+			}
+			return err
+		}
+		if err := bc.AddBlock(block); err != nil {
+			return err
+		}
+		p2p.ReportPeer(from, network.RewardUsefulBlock, "new block")
+		return nil
+	}
+}
+
+func registerHandlers(dispatcher *network.Dispatcher, handleBlock network.BlockHandler) {
+	dispatcher.Register(network.MsgBlock, func(from peer.ID, msg network.Payload) error {
+		return handleBlock(from, msg.(*network.BlockMessage).Block)
+	})
+	dispatcher.Register(network.MsgVote, func(from peer.ID, msg network.Payload) error {
+		vote := msg.(*network.VoteMessage).Vote
+		log.Printf("Received vote from %s for block %d", vote.Validator, vote.Height)
+		return nil
+	})
 }
 
 func processPendingTransactions(bc *blockchain.Blockchain, pos *consensus.ProofOfStake) {
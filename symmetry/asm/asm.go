@@ -0,0 +1,158 @@
+// Package asm translates between Symmetry bytecode and a textual assembly
+// language.
+//
+// Each line holds an optional label, an optional instruction and an
+// optional comment:
+//
+//   loop:   LOAD i          ; comments start with ; or #
+//           JMPIF done
+//
+// Mnemonics are the opcode names, in any case. Operands are written as:
+//
+//   values   10, -3, "text", true, 0xc0ffee (bytes), u256:10 (a 256-bit
+//            constant; integers beyond int64 are 256-bit anyway)
+//   names    x, or "any name" for names that aren't identifiers
+//   targets  a label, or @12 for a raw code offset
+package asm
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/bonniegachiengu/sustena_platforms/symmetry/vm"
+)
+
+var ErrSyntax = errors.New("syntax error")
+
+// Assemble translates assembly source to bytecode
+func Assemble(src string) ([]byte, error) {
+    program, err := Parse(src)
+    if err != nil {
+        return nil, err
+    }
+    return vm.Encode(program)
+}
+
+// Parse translates assembly source to instructions, with labels resolved
+// to code offsets
+func Parse(src string) ([]vm.Instruction, error) {
+    var program []vm.Instruction
+    // refs holds the label each jump or call refers to, by instruction
+    refs := make(map[int]string)
+    labels := make(map[string]int) // label to instruction index
+    lines := make(map[int]int)     // instruction index to source line
+
+    for n, line := range strings.Split(src, "\n") {
+        lineNo := n + 1
+        text, err := stripComment(line)
+        if err != nil {
+            return nil, fmt.Errorf("line %d: %w", lineNo, err)
+        }
+
+        if label, rest, ok := cutLabel(text); ok {
+            if _, exists := labels[label]; exists {
+                return nil, fmt.Errorf("line %d: %w: label %s defined twice", lineNo, ErrSyntax, label)
+            }
+            labels[label] = len(program)
+            text = rest
+        }
+        if text == "" {
+            continue
+        }
+
+        mnemonic, operand := text, ""
+        if i := strings.IndexAny(text, " \t"); i >= 0 {
+            mnemonic, operand = text[:i], strings.TrimSpace(text[i+1:])
+        }
+        op, ok := vm.ParseOpCode(strings.ToUpper(mnemonic))
+        if !ok {
+            return nil, fmt.Errorf("line %d: %w: unknown instruction %s", lineNo, ErrSyntax, mnemonic)
+        }
+
+        ins := vm.Instruction{OpCode: op}
+        switch op.Operand() {
+        case vm.OperandNone:
+            if operand != "" {
+                return nil, fmt.Errorf("line %d: %w: %s takes no operand", lineNo, ErrSyntax, op)
+            }
+        case vm.OperandValue:
+            ins.Operand, err = ParseValue(operand)
+        case vm.OperandName:
+            ins.Operand, err = parseName(operand)
+        case vm.OperandTarget:
+            if strings.HasPrefix(operand, "@") {
+                ins.Operand, err = parseOffset(operand)
+            } else if isIdentifier(operand) {
+                refs[len(program)] = operand
+                ins.Operand = uint32(0)
+            } else {
+                err = fmt.Errorf("%w: %s needs a label or @offset", ErrSyntax, op)
+            }
+        }
+        if err != nil {
+            return nil, fmt.Errorf("line %d: %w", lineNo, err)
+        }
+        lines[len(program)] = lineNo
+        program = append(program, ins)
+    }
+
+    // Target operands are always four bytes, so offsets don't depend on
+    // what the labels resolve to
+    offsets := vm.Offsets(program)
+    for i, label := range refs {
+        index, ok := labels[label]
+        if !ok {
+            return nil, fmt.Errorf("line %d: %w: undefined label %s", lines[i], ErrSyntax, label)
+        }
+        if index == len(program) {
+            return nil, fmt.Errorf("line %d: %w: label %s is at the end of the program", lines[i], ErrSyntax, label)
+        }
+        program[i].Operand = uint32(offsets[index])
+    }
+    return program, nil
+}
+
+// stripComment removes a trailing comment and surrounding space, leaving
+// ; and # inside strings alone
+func stripComment(line string) (string, error) {
+    quoted := false
+    for i := 0; i < len(line); i++ {
+        switch c := line[i]; {
+        case quoted && c == '\\':
+            i++
+        case c == '"':
+            quoted = !quoted
+        case !quoted && (c == ';' || c == '#'):
+            return strings.TrimSpace(line[:i]), nil
+        }
+    }
+    if quoted {
+        return "", fmt.Errorf("%w: unterminated string", ErrSyntax)
+    }
+    return strings.TrimSpace(line), nil
+}
+
+// cutLabel splits a leading "label:" off text
+func cutLabel(text string) (string, string, bool) {
+    i := strings.IndexByte(text, ':')
+    if i <= 0 || !isIdentifier(text[:i]) {
+        return "", text, false
+    }
+    return text[:i], strings.TrimSpace(text[i+1:]), true
+}
+
+func isIdentifier(s string) bool {
+    if s == "" {
+        return false
+    }
+    for i, c := range s {
+        switch {
+        case c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+        case i > 0 && c >= '0' && c <= '9':
+        default:
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,56 @@
+package asm
+
+import (
+    "bytes"
+    "testing"
+)
+
+// source uses every kind of operand, including the values a disassembler
+// could get wrong: integers above MaxInt64, small u256 constants, negative
+// integers and strings that need quoting
+const source = `
+start:
+    PUSH 3
+    STORE i
+loop:                               ; disassembly names labels after offsets
+    LOAD i
+    CALL decrement
+    DUP
+    STORE i
+    PUSH 0
+    GT
+    JMPIF loop
+    PUSH 9223372036854775808        # MaxInt64 + 1
+    PUSH 115792089237316195423570985008687907853269984665640564039457584007913129639935
+    PUSH u256:7
+    PUSH -42
+    PUSH -9223372036854775808
+    PUSH "semicolon; hash # and \"quotes\"\n"
+    PUSH ""
+    PUSH 0xc0ffee
+    PUSH true
+    STORE "not an identifier"
+    JMP @0
+decrement:
+    PUSH 1
+    SUB
+    RET
+`
+
+func TestDisassembleRoundTrip(t *testing.T) {
+    code, err := Assemble(source)
+    if err != nil {
+        t.Fatal(err)
+    }
+    text, err := Disassemble(code)
+    if err != nil {
+        t.Fatal(err)
+    }
+    again, err := Assemble(text)
+    if err != nil {
+        t.Fatalf("%v in disassembly:\n%s", err, text)
+    }
+    if !bytes.Equal(again, code) {
+        t.Fatalf("reassembled code differs:\n got %x\nwant %x\ndisassembly:\n%s", again, code, text)
+    }
+}
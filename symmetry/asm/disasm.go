@@ -0,0 +1,80 @@
+package asm
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/bonniegachiengu/sustena_platforms/symmetry/vm"
+)
+
+// Disassemble translates bytecode to annotated assembly. Assembling the
+// result gives back the same bytecode.
+func Disassemble(code []byte) (string, error) {
+    program, err := vm.Decode(code)
+    if err != nil {
+        return "", err
+    }
+    return Format(program), nil
+}
+
+// Format writes program as assembly. Jump targets are labelled L<offset>
+// and call targets fn<offset>; targets that aren't instructions stay raw
+// @offsets. Each line ends with a comment giving the instruction's index,
+// code offset and base gas cost.
+func Format(program []vm.Instruction) string {
+    offsets := vm.Offsets(program)
+    indexes := make(map[uint32]int, len(offsets))
+    for i, offset := range offsets {
+        indexes[uint32(offset)] = i
+    }
+
+    labels := make(map[int]string)
+    for _, ins := range program {
+        target, ok := ins.Operand.(uint32)
+        if !ok || ins.OpCode.Operand() != vm.OperandTarget {
+            continue
+        }
+        index, ok := indexes[target]
+        if !ok {
+            continue
+        }
+        if ins.OpCode == vm.CALL {
+            labels[index] = fmt.Sprintf("fn%d", target)
+        } else if labels[index] == "" {
+            labels[index] = fmt.Sprintf("L%d", target)
+        }
+    }
+
+    var b strings.Builder
+    fmt.Fprintf(&b, "; %d instructions\n", len(program))
+    for i, ins := range program {
+        if label, ok := labels[i]; ok {
+            fmt.Fprintf(&b, "%s:\n", label)
+        }
+        text := ins.OpCode.String()
+        if ins.Operand != nil {
+            text += " " + formatOperand(ins, indexes, labels)
+        }
+        fmt.Fprintf(&b, "    %-32s ; %d @%d gas %d\n", text, i, offsets[i], vm.GasCost(ins.OpCode))
+    }
+    return b.String()
+}
+
+func formatOperand(ins vm.Instruction, indexes map[uint32]int, labels map[int]string) string {
+    switch ins.OpCode.Operand() {
+    case vm.OperandName:
+        if name, ok := ins.Operand.(string); ok {
+            return formatName(name)
+        }
+    case vm.OperandTarget:
+        if target, ok := ins.Operand.(uint32); ok {
+            if index, ok := indexes[target]; ok {
+                return labels[index]
+            }
+            return fmt.Sprintf("@%d", target)
+        }
+    case vm.OperandValue:
+        return formatValue(ins.Operand)
+    }
+    return vm.FormatValue(ins.Operand)
+}
@@ -0,0 +1,108 @@
+package asm
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+
+    "github.com/bonniegachiengu/sustena_platforms/symmetry/vm"
+    "github.com/holiman/uint256"
+)
+
+// u256Prefix marks an integer that is encoded as a 256-bit constant even
+// though it fits in an int64
+const u256Prefix = "u256:"
+
+// ParseValue parses a value written as an instruction operand is, such as
+// 42, u256:7, "text", 0xc0ffee or true
+func ParseValue(s string) (interface{}, error) {
+    switch {
+    case s == "":
+        return nil, fmt.Errorf("%w: missing value", ErrSyntax)
+    case s == "true" || s == "false":
+        return s == "true", nil
+    case strings.HasPrefix(s, `"`):
+        return parseString(s)
+    case strings.HasPrefix(s, "0x"):
+        b, err := hex.DecodeString(s[2:])
+        if err != nil {
+            return nil, fmt.Errorf("%w: bad bytes %s", ErrSyntax, s)
+        }
+        return b, nil
+    case strings.HasPrefix(s, u256Prefix):
+        return parseU256(s[len(u256Prefix):])
+    }
+
+    v, err := strconv.ParseInt(s, 10, 64)
+    if errors.Is(err, strconv.ErrRange) && !strings.HasPrefix(s, "-") {
+        return parseU256(s)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%w: bad value %s", ErrSyntax, s)
+    }
+    return v, nil
+}
+
+func parseU256(s string) (*uint256.Int, error) {
+    v, err := uint256.FromDecimal(s)
+    if err != nil {
+        return nil, fmt.Errorf("%w: bad 256-bit integer %s", ErrSyntax, s)
+    }
+    return v, nil
+}
+
+func parseString(s string) (string, error) {
+    v, err := strconv.Unquote(s)
+    if err != nil {
+        return "", fmt.Errorf("%w: bad string %s", ErrSyntax, s)
+    }
+    return v, nil
+}
+
+func parseName(s string) (string, error) {
+    if strings.HasPrefix(s, `"`) {
+        name, err := parseString(s)
+        if err == nil && name == "" {
+            err = fmt.Errorf("%w: empty name", ErrSyntax)
+        }
+        return name, err
+    }
+    if !isIdentifier(s) {
+        return "", fmt.Errorf("%w: bad name %q", ErrSyntax, s)
+    }
+    return s, nil
+}
+
+func parseOffset(s string) (uint32, error) {
+    v, err := strconv.ParseUint(s[1:], 10, 32)
+    if err != nil {
+        return 0, fmt.Errorf("%w: bad offset %s", ErrSyntax, s)
+    }
+    return uint32(v), nil
+}
+
+// formatValue writes a value operand so that ParseValue reads back the
+// same constant
+func formatValue(v interface{}) string {
+    switch v := v.(type) {
+    case *uint256.Int:
+        if v.IsUint64() && v.Uint64() <= math.MaxInt64 {
+            return u256Prefix + v.Dec()
+        }
+        return v.Dec()
+    case string:
+        return strconv.Quote(v)
+    default:
+        return vm.FormatValue(v)
+    }
+}
+
+func formatName(name string) string {
+    if isIdentifier(name) {
+        return name
+    }
+    return strconv.Quote(name)
+}
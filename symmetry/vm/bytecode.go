@@ -0,0 +1,302 @@
+package vm
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+
+    "github.com/holiman/uint256"
+)
+
+// Bytecode layout:
+//
+//   header:      magic "SYMB" | version (1 byte)
+//   instruction: opcode (1 byte) | operand
+//
+// Operands depend on the opcode. OperandName is a uvarint length followed
+// by the name, OperandTarget a 4-byte big-endian code offset. OperandValue
+// starts with a type tag:
+//
+//   TagInt    zig-zag varint int64
+//   TagString uvarint length | UTF-8 bytes
+//   TagBool   1 byte, 0 or 1
+//   TagBytes  uvarint length | bytes
+//   TagU256   32-byte big-endian unsigned integer
+//
+// Code offsets count from the first byte after the header.
+
+// Magic identifies Symmetry bytecode
+var Magic = [4]byte{'S', 'Y', 'M', 'B'}
+
+// BytecodeVersion is the version of the format written by Encode
+const BytecodeVersion byte = 1
+
+// HeaderSize is the length of the bytecode header
+const HeaderSize = len(Magic) + 1
+
+// MaxCodeSize is the largest contract Verify accepts
+const MaxCodeSize = 24 * 1024
+
+// maxOperandSize caps string and byte operands
+const maxOperandSize = 1 << 16
+
+// Value operand type tags
+const (
+    TagInt    byte = 0x01
+    TagString byte = 0x02
+    TagBool   byte = 0x03
+    TagBytes  byte = 0x04
+    TagU256   byte = 0x05
+)
+
+var (
+    ErrBadMagic           = errors.New("not Symmetry bytecode")
+    ErrUnsupportedVersion = errors.New("unsupported bytecode version")
+    ErrTruncated          = errors.New("truncated bytecode")
+    ErrUnknownOpcode      = errors.New("unknown opcode")
+    ErrBadOperand         = errors.New("invalid operand")
+    ErrCodeSize           = errors.New("code too large")
+)
+
+// Encode serializes a program to bytecode
+func Encode(program []Instruction) ([]byte, error) {
+    var buf bytes.Buffer
+    buf.Write(Magic[:])
+    buf.WriteByte(BytecodeVersion)
+    for i, ins := range program {
+        if err := encodeInstruction(&buf, ins); err != nil {
+            return nil, fmt.Errorf("instruction %d (%s): %w", i, ins.OpCode, err)
+        }
+    }
+    return buf.Bytes(), nil
+}
+
+// Decode parses bytecode produced by Encode
+func Decode(code []byte) ([]Instruction, error) {
+    program, _, err := decodeProgram(code)
+    return program, err
+}
+
+// decodeProgram parses bytecode and also returns the code offset of each
+// instruction
+func decodeProgram(code []byte) ([]Instruction, []int, error) {
+    if len(code) < HeaderSize {
+        return nil, nil, ErrTruncated
+    }
+    if !bytes.Equal(code[:len(Magic)], Magic[:]) {
+        return nil, nil, ErrBadMagic
+    }
+    if v := code[len(Magic)]; v != BytecodeVersion {
+        return nil, nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+    }
+
+    body := code[HeaderSize:]
+    d := &codeReader{buf: body}
+    var program []Instruction
+    var offsets []int
+    for len(d.buf) > 0 {
+        offset := len(body) - len(d.buf)
+        ins, err := d.instruction()
+        if err != nil {
+            return nil, nil, fmt.Errorf("offset %d: %w", offset, err)
+        }
+        program = append(program, ins)
+        offsets = append(offsets, offset)
+    }
+    return program, offsets, nil
+}
+
+func encodeInstruction(buf *bytes.Buffer, ins Instruction) error {
+    if !ins.OpCode.Valid() {
+        return ErrUnknownOpcode
+    }
+    buf.WriteByte(byte(ins.OpCode))
+
+    switch ins.OpCode.Operand() {
+    case OperandNone:
+        if ins.Operand != nil {
+            return fmt.Errorf("%w: %s takes no operand", ErrBadOperand, ins.OpCode)
+        }
+    case OperandName:
+        name, ok := ins.Operand.(string)
+        if !ok || name == "" {
+            return fmt.Errorf("%w: %s needs a variable name", ErrBadOperand, ins.OpCode)
+        }
+        putBytes(buf, []byte(name))
+    case OperandTarget:
+        target, ok := ins.Operand.(uint32)
+        if !ok {
+            return fmt.Errorf("%w: %s needs a uint32 code offset", ErrBadOperand, ins.OpCode)
+        }
+        var tmp [4]byte
+        binary.BigEndian.PutUint32(tmp[:], target)
+        buf.Write(tmp[:])
+    case OperandValue:
+        return encodeValue(buf, ins.Operand)
+    }
+    return nil
+}
+
+func encodeValue(buf *bytes.Buffer, v interface{}) error {
+    switch v := v.(type) {
+    case int64:
+        buf.WriteByte(TagInt)
+        putVarint(buf, v)
+    case string:
+        buf.WriteByte(TagString)
+        putBytes(buf, []byte(v))
+    case bool:
+        buf.WriteByte(TagBool)
+        if v {
+            buf.WriteByte(1)
+        } else {
+            buf.WriteByte(0)
+        }
+    case []byte:
+        buf.WriteByte(TagBytes)
+        putBytes(buf, v)
+    case *uint256.Int:
+        buf.WriteByte(TagU256)
+        word := v.Bytes32()
+        buf.Write(word[:])
+    default:
+        return fmt.Errorf("%w: unsupported constant %v (%T)", ErrBadOperand, v, v)
+    }
+    return nil
+}
+
+// Offsets returns the code offset of each instruction of program, the
+// values jump and call operands refer to
+func Offsets(program []Instruction) []int {
+    offsets, _ := codeOffsets(program)
+    return offsets
+}
+
+// codeOffsets returns the code offset of each instruction of program and
+// the total code size. Instructions that can't be encoded count as one
+// byte; Encode reports them.
+func codeOffsets(program []Instruction) ([]int, int) {
+    offsets := make([]int, len(program))
+    offset := 0
+    var buf bytes.Buffer
+    for i, ins := range program {
+        offsets[i] = offset
+        buf.Reset()
+        if err := encodeInstruction(&buf, ins); err != nil {
+            offset++
+            continue
+        }
+        offset += buf.Len()
+    }
+    return offsets, offset
+}
+
+func putVarint(buf *bytes.Buffer, v int64) {
+    var tmp [binary.MaxVarintLen64]byte
+    buf.Write(tmp[:binary.PutVarint(tmp[:], v)])
+}
+
+func putBytes(buf *bytes.Buffer, b []byte) {
+    var tmp [binary.MaxVarintLen64]byte
+    buf.Write(tmp[:binary.PutUvarint(tmp[:], uint64(len(b)))])
+    buf.Write(b)
+}
+
+// codeReader decodes instructions from bytecode
+type codeReader struct {
+    buf []byte
+}
+
+func (d *codeReader) instruction() (Instruction, error) {
+    op := OpCode(d.buf[0])
+    d.buf = d.buf[1:]
+    if !op.Valid() {
+        return Instruction{}, fmt.Errorf("%w 0x%02x", ErrUnknownOpcode, byte(op))
+    }
+
+    ins := Instruction{OpCode: op}
+    switch op.Operand() {
+    case OperandName:
+        name, err := d.bytes()
+        if err != nil {
+            return Instruction{}, err
+        }
+        if len(name) == 0 {
+            return Instruction{}, fmt.Errorf("%w: empty variable name", ErrBadOperand)
+        }
+        ins.Operand = string(name)
+    case OperandTarget:
+        if len(d.buf) < 4 {
+            return Instruction{}, ErrTruncated
+        }
+        ins.Operand = binary.BigEndian.Uint32(d.buf)
+        d.buf = d.buf[4:]
+    case OperandValue:
+        v, err := d.value()
+        if err != nil {
+            return Instruction{}, err
+        }
+        ins.Operand = v
+    }
+    return ins, nil
+}
+
+func (d *codeReader) value() (interface{}, error) {
+    if len(d.buf) == 0 {
+        return nil, ErrTruncated
+    }
+    tag := d.buf[0]
+    d.buf = d.buf[1:]
+
+    switch tag {
+    case TagInt:
+        v, n := binary.Varint(d.buf)
+        if n <= 0 {
+            return nil, ErrTruncated
+        }
+        d.buf = d.buf[n:]
+        return v, nil
+    case TagString:
+        b, err := d.bytes()
+        return string(b), err
+    case TagBool:
+        if len(d.buf) == 0 {
+            return nil, ErrTruncated
+        }
+        b := d.buf[0]
+        d.buf = d.buf[1:]
+        if b > 1 {
+            return nil, fmt.Errorf("%w: bool %d", ErrBadOperand, b)
+        }
+        return b == 1, nil
+    case TagBytes:
+        return d.bytes()
+    case TagU256:
+        if len(d.buf) < 32 {
+            return nil, ErrTruncated
+        }
+        v := new(uint256.Int).SetBytes32(d.buf[:32])
+        d.buf = d.buf[32:]
+        return v, nil
+    default:
+        return nil, fmt.Errorf("%w: type tag 0x%02x", ErrBadOperand, tag)
+    }
+}
+
+func (d *codeReader) bytes() ([]byte, error) {
+    n, read := binary.Uvarint(d.buf)
+    if read <= 0 {
+        return nil, ErrTruncated
+    }
+    if n > maxOperandSize {
+        return nil, fmt.Errorf("%w: %d byte operand", ErrBadOperand, n)
+    }
+    d.buf = d.buf[read:]
+    if uint64(len(d.buf)) < n {
+        return nil, ErrTruncated
+    }
+    b := append([]byte(nil), d.buf[:n]...)
+    d.buf = d.buf[n:]
+    return b, nil
+}
@@ -0,0 +1,78 @@
+package vm
+
+import (
+    "errors"
+)
+
+var (
+    ErrWriteProtection = errors.New("state change in a read-only call")
+    ErrReentrancy      = errors.New("reentrant call")
+)
+
+// Call describes a call from one contract to another
+type Call struct {
+    Address string
+    // Value is moved from the caller to the callee before the callee runs
+    // and moved back if the call fails
+    Value int64
+    Input interface{}
+    Gas   uint64
+    // Static calls run read-only: the callee and everything it calls may
+    // not write storage, transfer funds or emit logs
+    Static bool
+}
+
+// ReentrancyPolicy decides whether a contract may be called while an
+// earlier call to it is still running
+type ReentrancyPolicy byte
+
+const (
+    // ReentrancyAllow lets a contract be re-entered freely
+    ReentrancyAllow ReentrancyPolicy = iota
+    // ReentrancyStaticOnly lets a contract be re-entered by static calls,
+    // which can read its storage but not change it
+    ReentrancyStaticOnly
+    // ReentrancyDeny rejects every call into a contract that is already
+    // running
+    ReentrancyDeny
+)
+
+// Allows reports whether a call that re-enters a contract with policy p
+// may go ahead
+func (p ReentrancyPolicy) Allows(static bool) bool {
+    switch p {
+    case ReentrancyDeny:
+        return false
+    case ReentrancyStaticOnly:
+        return static
+    }
+    return true
+}
+
+// SetReadOnly makes the VM reject state changes through its host. Calls the
+// VM makes are static as well.
+func (vm *VM) SetReadOnly(readOnly bool) {
+    vm.readOnly = readOnly
+}
+
+// Execute runs code on a new VM attached to host, the way hosts run a
+// callee. If the callee reverts, the result is its revert payload.
+//
+// The code must have passed Verify, as deployed contracts have; it isn't
+// checked again, since a call's gas doesn't pay for verification.
+func Execute(host Host, code []byte, call Call) (interface{}, uint64, error) {
+    machine := NewVM()
+    if err := machine.LoadVerified(code); err != nil {
+        return nil, 0, err
+    }
+    machine.SetHost(host)
+    machine.SetInput(call.Input)
+    machine.SetReadOnly(call.Static)
+
+    used, err := machine.Run(call.Gas)
+    var revert *RevertError
+    if errors.As(err, &revert) {
+        return revert.Payload, used, err
+    }
+    return machine.Result(), used, err
+}
@@ -0,0 +1,170 @@
+package vm
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+)
+
+var (
+    ErrNotStarted = errors.New("debugger not started")
+    ErrHalted     = errors.New("program has ended")
+)
+
+// Debugger runs a VM one instruction at a time, stopping at breakpoints so
+// that the state can be inspected. Breakpoints are instruction indexes, as
+// in Step.PC. The run commits or reverts its changes like Run once the
+// program ends.
+type Debugger struct {
+    vm          *VM
+    breakpoints map[int]bool
+    // tracer is the VM's own tracer, which still sees every step
+    tracer Tracer
+    last   *Step
+
+    started bool
+    done    bool
+    err     error
+}
+
+func NewDebugger(vm *VM) *Debugger {
+    return &Debugger{vm: vm, breakpoints: make(map[int]bool)}
+}
+
+// Start prepares the loaded program to run with at most gasLimit gas
+func (d *Debugger) Start(gasLimit uint64) error {
+    if err := d.vm.start(gasLimit); err != nil {
+        return err
+    }
+    d.tracer = d.vm.tracer
+    d.vm.tracer = d
+    d.started, d.done, d.err, d.last = true, false, nil, nil
+    if d.vm.halted() {
+        d.end(nil)
+    }
+    return nil
+}
+
+// AddBreakpoint stops Continue before the instruction at pc runs
+func (d *Debugger) AddBreakpoint(pc int) error {
+    if pc < 0 || pc >= len(d.vm.program) {
+        return fmt.Errorf("no instruction %d", pc)
+    }
+    d.breakpoints[pc] = true
+    return nil
+}
+
+func (d *Debugger) RemoveBreakpoint(pc int) {
+    delete(d.breakpoints, pc)
+}
+
+// Breakpoints returns the breakpoints in order
+func (d *Debugger) Breakpoints() []int {
+    pcs := make([]int, 0, len(d.breakpoints))
+    for pc := range d.breakpoints {
+        pcs = append(pcs, pc)
+    }
+    sort.Ints(pcs)
+    return pcs
+}
+
+// Step runs one instruction and returns what it did. An error in the
+// program ends it and is in the step; Step itself only fails when there
+// is nothing to run.
+func (d *Debugger) Step() (*Step, error) {
+    if !d.started {
+        return nil, ErrNotStarted
+    }
+    if d.done {
+        return nil, ErrHalted
+    }
+    err := d.vm.step()
+    if err != nil || d.vm.halted() {
+        d.end(err)
+    }
+    return d.last, nil
+}
+
+// Continue runs until the next instruction has a breakpoint or the
+// program ends, and returns the last step run
+func (d *Debugger) Continue() (*Step, error) {
+    step, err := d.Step()
+    for err == nil && !d.done && !d.breakpoints[d.vm.pc] {
+        step, err = d.Step()
+    }
+    return step, err
+}
+
+func (d *Debugger) end(err error) {
+    d.vm.finish(err)
+    d.vm.tracer = d.tracer
+    d.done, d.err = true, err
+}
+
+func (d *Debugger) OnStep(step *Step) {
+    d.last = step
+    if d.tracer != nil {
+        d.tracer.OnStep(step)
+    }
+}
+
+func (d *Debugger) OnEnd(gasUsed uint64, err error) {
+    if d.tracer != nil {
+        d.tracer.OnEnd(gasUsed, err)
+    }
+}
+
+// Done reports whether the program has ended
+func (d *Debugger) Done() bool {
+    return d.done
+}
+
+// Err returns the error the program ended with
+func (d *Debugger) Err() error {
+    return d.err
+}
+
+// PC returns the index of the next instruction to run
+func (d *Debugger) PC() int {
+    return d.vm.pc
+}
+
+// Next returns the next instruction to run, if any
+func (d *Debugger) Next() (Instruction, bool) {
+    if d.done || d.vm.halted() {
+        return Instruction{}, false
+    }
+    return d.vm.program[d.vm.pc], true
+}
+
+func (d *Debugger) GasUsed() uint64 {
+    return d.vm.gasUsed
+}
+
+// Stack returns a copy of the stack, bottom first
+func (d *Debugger) Stack() []interface{} {
+    return append([]interface{}(nil), d.vm.stack...)
+}
+
+// Memory returns a copy of the global variables
+func (d *Debugger) Memory() map[string]interface{} {
+    return copyVariables(d.vm.memory)
+}
+
+// Locals returns a copy of the current function's local variables
+func (d *Debugger) Locals() map[string]interface{} {
+    return copyVariables(d.vm.frame().locals)
+}
+
+// CallDepth returns the number of active function calls
+func (d *Debugger) CallDepth() int {
+    return len(d.vm.frames) - 1
+}
+
+func copyVariables(vars map[string]interface{}) map[string]interface{} {
+    out := make(map[string]interface{}, len(vars))
+    for k, v := range vars {
+        out[k] = v
+    }
+    return out
+}
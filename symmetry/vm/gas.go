@@ -0,0 +1,185 @@
+package vm
+
+import "github.com/holiman/uint256"
+
+// Gas costs by opcode. Every instruction is charged before it runs; an
+// instruction that would take the total past the gas limit is not run.
+const (
+    GasZero    uint64 = 0
+    GasQuick   uint64 = 2
+    GasFastest uint64 = 3
+    GasFast    uint64 = 5
+    GasMid     uint64 = 8
+    GasSlow    uint64 = 10
+
+    // GasLoad and GasStore are charged for global memory access; storing
+    // to a variable that doesn't exist yet costs GasStoreNew instead
+    GasLoad     uint64 = 50
+    GasStore    uint64 = 100
+    GasStoreNew uint64 = 200
+
+    // GasCall is charged for entering a function
+    GasCall uint64 = 20
+
+    // Host operations. SSTORE to a key the contract hasn't written before
+    // costs GasSStoreNew. Calls also charge the gas the callee uses, and
+    // GasCallValue more if they move value.
+    GasBalance      uint64 = 100
+    GasSLoad        uint64 = 100
+    GasSStore       uint64 = 200
+    GasSStoreNew    uint64 = 400
+    GasTransfer     uint64 = 300
+    GasLog          uint64 = 50
+    GasCallContract uint64 = 100
+    GasCallValue    uint64 = 300
+
+    // GasPerWord is charged per 32 bytes of string or byte data pushed,
+    // produced by CONCAT, logged or hashed by a precompile
+    GasPerWord uint64 = 3
+)
+
+// Execution limits
+const (
+    // MaxStackSize is the largest number of values on the stack
+    MaxStackSize = 1024
+    // MaxMemorySlots is the largest number of global variables
+    MaxMemorySlots = 4096
+    // MaxLocals is the largest number of local variables in one frame
+    MaxLocals = 256
+    // MaxValueSize is the largest string or byte value in bytes
+    MaxValueSize = 1 << 16
+)
+
+var gasTable = map[OpCode]uint64{
+    STOP: GasZero,
+
+    ADD:    GasFastest,
+    SUB:    GasFastest,
+    WADD:   GasFastest,
+    WSUB:   GasFastest,
+    NEG:    GasFastest,
+    MUL:    GasFast,
+    WMUL:   GasFast,
+    DIV:    GasFast,
+    MOD:    GasFast,
+    SDIV:   GasFast,
+    SMOD:   GasFast,
+    CONCAT: GasFastest,
+
+    LT:  GasFastest,
+    GT:  GasFastest,
+    LE:  GasFastest,
+    GE:  GasFastest,
+    EQ:  GasFastest,
+    NE:  GasFastest,
+    SLT: GasFastest,
+    SGT: GasFastest,
+    SLE: GasFastest,
+    SGE: GasFastest,
+    AND: GasFastest,
+    OR:  GasFastest,
+    NOT: GasFastest,
+
+    POP:  GasQuick,
+    PUSH: GasFastest,
+    DUP:  GasFastest,
+    SWAP: GasFastest,
+
+    LOAD:   GasLoad,
+    STORE:  GasStore,
+    LLOAD:  GasFastest,
+    LSTORE: GasFastest,
+
+    JMP:    GasMid,
+    JMPIF:  GasSlow,
+    CALL:   GasCall,
+    RET:    GasMid,
+    RETURN: GasZero,
+    REVERT: GasZero,
+    INPUT:  GasQuick,
+
+    ADDRESS:      GasQuick,
+    CALLER:       GasQuick,
+    NUMBER:       GasQuick,
+    TIMESTAMP:    GasQuick,
+    BALANCE:      GasBalance,
+    SLOAD:        GasSLoad,
+    SSTORE:       GasSStore,
+    TRANSFER:     GasTransfer,
+    LOG:          GasLog,
+    CALLCONTRACT: GasCallContract,
+    STATICCALL:   GasCallContract,
+
+    // NATIVE costs what its precompile charges
+    NATIVE: GasZero,
+}
+
+// GasCost returns the static gas cost of op
+func GasCost(op OpCode) uint64 {
+    return gasTable[op]
+}
+
+// gasCost returns what ins costs to run in the current state, including
+// charges that depend on its operands
+func (vm *VM) gasCost(ins Instruction) uint64 {
+    cost := GasCost(ins.OpCode)
+    switch ins.OpCode {
+    case PUSH:
+        cost += wordGas(valueSize(ins.Operand))
+    case CONCAT:
+        if len(vm.stack) >= 2 {
+            n := len(vm.stack)
+            cost += wordGas(valueSize(vm.stack[n-2]) + valueSize(vm.stack[n-1]))
+        }
+    case STORE:
+        if key, ok := ins.Operand.(string); ok {
+            if _, exists := vm.memory[key]; !exists {
+                cost = GasStoreNew
+            }
+        }
+    case SSTORE:
+        if len(vm.stack) >= 2 && vm.host != nil {
+            key, ok := vm.stack[len(vm.stack)-2].(string)
+            if _, exists := vm.host.GetStorage(key); ok && !exists {
+                cost = GasSStoreNew
+            }
+        }
+    case LOG:
+        if len(vm.stack) >= 1 {
+            cost += wordGas(valueSize(vm.stack[len(vm.stack)-1]))
+        }
+    case NATIVE:
+        if p, ok := LookupPrecompile(ins.Operand.(string)); ok {
+            cost += p.Gas
+            if p.PerWord && len(vm.stack) >= p.Args {
+                size := 0
+                for _, arg := range vm.stack[len(vm.stack)-p.Args:] {
+                    size += valueSize(arg)
+                }
+                cost += wordGas(size)
+            }
+        }
+    case CALLCONTRACT:
+        if len(vm.stack) >= 4 {
+            if value, ok := vm.stack[len(vm.stack)-3].(*uint256.Int); ok && !value.IsZero() {
+                cost += GasCallValue
+            }
+        }
+    }
+    return cost
+}
+
+// valueSize is the length of string and byte values, and zero otherwise
+func valueSize(v interface{}) int {
+    switch v := v.(type) {
+    case string:
+        return len(v)
+    case []byte:
+        return len(v)
+    }
+    return 0
+}
+
+func wordGas(size int) uint64 {
+    return uint64((size+31)/32) * GasPerWord
+}
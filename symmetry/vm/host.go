@@ -0,0 +1,225 @@
+package vm
+
+import (
+    "errors"
+    "fmt"
+    "math"
+
+    "github.com/holiman/uint256"
+)
+
+// ErrNoHost is returned by host opcodes when the VM has no host
+var ErrNoHost = errors.New("no host attached")
+
+// Host connects a running contract to the chain. A host is bound to one
+// contract call: Caller and Address describe that call, and storage,
+// transfers and logs belong to the contract at Address.
+type Host interface {
+    // Caller is the account or contract that made the call
+    Caller() string
+    // Address is the address of the running contract
+    Address() string
+    BlockNumber() int64
+    Timestamp() int64
+
+    GetBalance(address string) int64
+    // Transfer moves amount from the running contract to another account
+    Transfer(to string, amount int64) error
+
+    // GetStorage reads a key of the running contract's storage
+    GetStorage(key string) (interface{}, bool)
+    // SetStorage writes a key of the running contract's storage
+    SetStorage(key string, value interface{})
+
+    EmitLog(topic string, data interface{})
+
+    // Checkpoint marks the current state. RevertTo undoes every change
+    // made since a checkpoint and Commit keeps them; both close it.
+    Checkpoint() int
+    RevertTo(checkpoint int)
+    Commit(checkpoint int)
+
+    // CallContract runs a call with the calling contract as caller and
+    // returns the callee's result and the gas it used. A failed call's
+    // changes, including the value moved, are reverted, and its result is
+    // the revert payload if the callee reverted.
+    CallContract(call Call) (interface{}, uint64, error)
+}
+
+// Log is an event emitted by a contract
+type Log struct {
+    Address string
+    Topic   string
+    Data    interface{}
+}
+
+// SetHost attaches the host that host opcodes call into
+func (vm *VM) SetHost(host Host) {
+    vm.host = host
+}
+
+// SetInput sets the value INPUT pushes
+func (vm *VM) SetInput(input interface{}) {
+    vm.input = input
+}
+
+// Result returns the value passed to RETURN, or nil if the program ended
+// without returning one
+func (vm *VM) Result() interface{} {
+    return vm.result
+}
+
+// executeHost runs the opcodes that need a host
+func (vm *VM) executeHost(instruction Instruction) error {
+    if vm.host == nil {
+        return ErrNoHost
+    }
+    switch instruction.OpCode {
+    case ADDRESS:
+        vm.push(vm.host.Address())
+    case CALLER:
+        vm.push(vm.host.Caller())
+    case NUMBER:
+        vm.push(int64Word(vm.host.BlockNumber()))
+    case TIMESTAMP:
+        vm.push(int64Word(vm.host.Timestamp()))
+    case BALANCE:
+        args, err := vm.popN(1)
+        if err != nil {
+            return err
+        }
+        address, ok := args[0].(string)
+        if !ok {
+            return fmt.Errorf("%w: address %T is not a string", ErrTypeMismatch, args[0])
+        }
+        vm.push(int64Word(vm.host.GetBalance(address)))
+    case TRANSFER:
+        if vm.readOnly {
+            return ErrWriteProtection
+        }
+        args, err := vm.popN(2)
+        if err != nil {
+            return err
+        }
+        to, ok := args[0].(string)
+        if !ok {
+            return fmt.Errorf("%w: address %T is not a string", ErrTypeMismatch, args[0])
+        }
+        amount, err := amountOf(args[1])
+        if err != nil {
+            return err
+        }
+        return vm.host.Transfer(to, amount)
+    case SLOAD:
+        args, err := vm.popN(1)
+        if err != nil {
+            return err
+        }
+        key, ok := args[0].(string)
+        if !ok {
+            return fmt.Errorf("%w: storage key %T is not a string", ErrTypeMismatch, args[0])
+        }
+        value, exists := vm.host.GetStorage(key)
+        if !exists {
+            value = new(uint256.Int)
+        }
+        vm.push(value)
+    case SSTORE:
+        if vm.readOnly {
+            return ErrWriteProtection
+        }
+        args, err := vm.popN(2)
+        if err != nil {
+            return err
+        }
+        key, ok := args[0].(string)
+        if !ok {
+            return fmt.Errorf("%w: storage key %T is not a string", ErrTypeMismatch, args[0])
+        }
+        vm.host.SetStorage(key, args[1])
+    case LOG:
+        if vm.readOnly {
+            return ErrWriteProtection
+        }
+        args, err := vm.popN(2)
+        if err != nil {
+            return err
+        }
+        topic, ok := args[0].(string)
+        if !ok {
+            return fmt.Errorf("%w: log topic %T is not a string", ErrTypeMismatch, args[0])
+        }
+        vm.host.EmitLog(topic, args[1])
+    case CALLCONTRACT, STATICCALL:
+        return vm.callContract(instruction.OpCode)
+    }
+    return nil
+}
+
+// callContract pops a call's arguments, makes the call and pushes its
+// result and whether it succeeded. A failed callee doesn't stop the
+// caller, which decides what to do from the flag and the result.
+func (vm *VM) callContract(op OpCode) error {
+    // CALLCONTRACT takes address, value, gas, input; STATICCALL has no value
+    n := 4
+    if op == STATICCALL {
+        n = 3
+    }
+    args, err := vm.popN(n)
+    if err != nil {
+        return err
+    }
+    address, ok := args[0].(string)
+    if !ok {
+        return fmt.Errorf("%w: address %T is not a string", ErrTypeMismatch, args[0])
+    }
+    var value int64
+    if op == CALLCONTRACT {
+        if value, err = amountOf(args[1]); err != nil {
+            return err
+        }
+    }
+    gas, ok := args[n-2].(*uint256.Int)
+    if !ok {
+        return fmt.Errorf("%w: gas %T is not an integer", ErrTypeMismatch, args[n-2])
+    }
+
+    static := op == STATICCALL || vm.readOnly
+    if static && value > 0 {
+        return ErrWriteProtection
+    }
+
+    // The callee gets the gas asked for, up to what the caller has left
+    available := vm.gasLimit - vm.gasUsed
+    forward := available
+    if gas.IsUint64() && gas.Uint64() < available {
+        forward = gas.Uint64()
+    }
+
+    result, used, err := vm.host.CallContract(Call{
+        Address: address,
+        Value:   value,
+        Input:   args[n-1],
+        Gas:     forward,
+        Static:  static,
+    })
+    vm.gasUsed += used
+    if result == nil {
+        result = []byte{}
+    }
+    vm.push(result)
+    vm.push(err == nil)
+    return nil
+}
+
+// amountOf converts a word to a transfer amount
+func amountOf(v interface{}) (int64, error) {
+    w, ok := v.(*uint256.Int)
+    if !ok {
+        return 0, fmt.Errorf("%w: amount %T is not an integer", ErrTypeMismatch, v)
+    }
+    if !w.IsUint64() || w.Uint64() > math.MaxInt64 {
+        return 0, fmt.Errorf("%w: amount %s", ErrOverflow, w.Dec())
+    }
+    return int64(w.Uint64()), nil
+}
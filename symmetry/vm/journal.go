@@ -0,0 +1,57 @@
+package vm
+
+// Journal records how to undo state changes so that they can be rolled
+// back to a checkpoint. Checkpoints nest: a sub-call opens one inside its
+// caller's, and committing it leaves its changes to the caller's
+// checkpoint to keep or revert.
+type Journal struct {
+    undo []func()
+    // checkpoints holds the number of undo entries when each open
+    // checkpoint was taken, innermost last
+    checkpoints []int
+}
+
+func NewJournal() *Journal {
+    return &Journal{}
+}
+
+// Record adds the undo function for a change that was just made. Changes
+// made with no checkpoint open can't be reverted and aren't kept.
+func (j *Journal) Record(undo func()) {
+    if len(j.checkpoints) == 0 {
+        return
+    }
+    j.undo = append(j.undo, undo)
+}
+
+// Checkpoint opens a checkpoint and returns its id
+func (j *Journal) Checkpoint() int {
+    j.checkpoints = append(j.checkpoints, len(j.undo))
+    return len(j.checkpoints) - 1
+}
+
+// RevertTo undoes every change recorded since checkpoint id, newest first,
+// and closes it along with any checkpoints opened after it
+func (j *Journal) RevertTo(id int) {
+    if id < 0 || id >= len(j.checkpoints) {
+        return
+    }
+    mark := j.checkpoints[id]
+    for i := len(j.undo) - 1; i >= mark; i-- {
+        j.undo[i]()
+    }
+    j.undo = j.undo[:mark]
+    j.checkpoints = j.checkpoints[:id]
+}
+
+// Commit closes checkpoint id, and any opened after it, keeping their
+// changes. Committing the outermost checkpoint makes the changes final.
+func (j *Journal) Commit(id int) {
+    if id < 0 || id >= len(j.checkpoints) {
+        return
+    }
+    j.checkpoints = j.checkpoints[:id]
+    if id == 0 {
+        j.undo = nil
+    }
+}
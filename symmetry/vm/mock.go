@@ -0,0 +1,149 @@
+package vm
+
+import (
+    "errors"
+    "fmt"
+)
+
+// MockHost is an in-memory Host for tests and tools. Contracts in Code can
+// be called with CALLCONTRACT; each call runs in a new VM with a MockHost
+// that shares this one's state and journal. Create one with NewMockHost.
+type MockHost struct {
+    CallerAddr  string
+    Self        string
+    BlockHeight int64
+    Time        int64
+
+    Balances map[string]int64
+    // Storage maps contract addresses to their storage
+    Storage map[string]map[string]interface{}
+    // Code maps contract addresses to their bytecode
+    Code map[string][]byte
+    // Policies holds the reentrancy policy of contracts that don't allow
+    // reentry
+    Policies map[string]ReentrancyPolicy
+    // Logs holds every log emitted through this host and its callees
+    Logs []Log
+
+    journal *Journal
+    root    *MockHost // the host Logs are kept on
+    running []string  // contracts on the call stack, outermost first
+}
+
+var _ Host = (*MockHost)(nil)
+
+// NewMockHost returns an empty MockHost for a contract at self called by
+// caller
+func NewMockHost(caller, self string) *MockHost {
+    h := &MockHost{
+        CallerAddr: caller,
+        Self:       self,
+        Balances:   make(map[string]int64),
+        Storage:    make(map[string]map[string]interface{}),
+        Code:       make(map[string][]byte),
+        Policies:   make(map[string]ReentrancyPolicy),
+        journal:    NewJournal(),
+        running:    []string{self},
+    }
+    h.root = h
+    return h
+}
+
+func (h *MockHost) Caller() string     { return h.CallerAddr }
+func (h *MockHost) Address() string    { return h.Self }
+func (h *MockHost) BlockNumber() int64 { return h.BlockHeight }
+func (h *MockHost) Timestamp() int64   { return h.Time }
+
+func (h *MockHost) GetBalance(address string) int64 {
+    return h.Balances[address]
+}
+
+func (h *MockHost) Transfer(to string, amount int64) error {
+    if amount < 0 {
+        return fmt.Errorf("negative amount %d", amount)
+    }
+    if h.Balances[h.Self] < amount {
+        return errors.New("insufficient balance")
+    }
+    from := h.Self
+    h.Balances[from] -= amount
+    h.Balances[to] += amount
+    h.journal.Record(func() {
+        h.Balances[from] += amount
+        h.Balances[to] -= amount
+    })
+    return nil
+}
+
+func (h *MockHost) GetStorage(key string) (interface{}, bool) {
+    value, exists := h.Storage[h.Self][key]
+    return value, exists
+}
+
+func (h *MockHost) SetStorage(key string, value interface{}) {
+    storage := h.Storage[h.Self]
+    if storage == nil {
+        storage = make(map[string]interface{})
+        h.Storage[h.Self] = storage
+    }
+    previous, existed := storage[key]
+    h.journal.Record(func() {
+        if existed {
+            storage[key] = previous
+        } else {
+            delete(storage, key)
+        }
+    })
+    storage[key] = value
+}
+
+func (h *MockHost) EmitLog(topic string, data interface{}) {
+    root := h.root
+    n := len(root.Logs)
+    root.Logs = append(root.Logs, Log{Address: h.Self, Topic: topic, Data: data})
+    h.journal.Record(func() { root.Logs = root.Logs[:n] })
+}
+
+func (h *MockHost) Checkpoint() int         { return h.journal.Checkpoint() }
+func (h *MockHost) RevertTo(checkpoint int) { h.journal.RevertTo(checkpoint) }
+func (h *MockHost) Commit(checkpoint int)   { h.journal.Commit(checkpoint) }
+
+func (h *MockHost) CallContract(call Call) (interface{}, uint64, error) {
+    code, exists := h.Code[call.Address]
+    if !exists {
+        return nil, 0, fmt.Errorf("no contract at %s", call.Address)
+    }
+    // Code is set directly rather than deployed, so check it here
+    if err := Verify(code); err != nil {
+        return nil, 0, err
+    }
+    if len(h.running) > MaxCallDepth {
+        return nil, 0, ErrCallDepth
+    }
+    for _, address := range h.running {
+        if address == call.Address && !h.Policies[call.Address].Allows(call.Static) {
+            return nil, 0, fmt.Errorf("%w into %s", ErrReentrancy, call.Address)
+        }
+    }
+
+    callee := *h
+    callee.CallerAddr = h.Self
+    callee.Self = call.Address
+    callee.Logs = nil
+    callee.running = append(h.running[:len(h.running):len(h.running)], call.Address)
+
+    checkpoint := h.journal.Checkpoint()
+    if call.Value > 0 {
+        if err := h.Transfer(call.Address, call.Value); err != nil {
+            h.journal.RevertTo(checkpoint)
+            return nil, 0, err
+        }
+    }
+    result, used, err := Execute(&callee, code, call)
+    if err != nil {
+        h.journal.RevertTo(checkpoint)
+    } else {
+        h.journal.Commit(checkpoint)
+    }
+    return result, used, err
+}
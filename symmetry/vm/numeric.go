@@ -0,0 +1,194 @@
+package vm
+
+import (
+    "bytes"
+    "fmt"
+
+    "github.com/holiman/uint256"
+)
+
+// Integers on the stack are 256-bit words. Unsigned opcodes treat them as
+// 0..2^256-1 and signed ones (SDIV, SMOD, SLT, ...) as two's complement.
+// ADD, SUB and MUL fail on unsigned overflow; WADD, WSUB and WMUL wrap
+// modulo 2^256, which also gives correct signed results. SDIV fails on the
+// one signed overflow, -2^255 / -1.
+//
+// Strings, bools and bytes are never converted to numbers or back.
+
+// minInt256 is -2^255, the smallest signed word
+var minInt256 = new(uint256.Int).Lsh(uint256.NewInt(1), 255)
+
+// minusOne is -1 as a signed word
+var minusOne = new(uint256.Int).Not(new(uint256.Int))
+
+// Constant converts a PUSH operand to the value it puts on the stack
+func Constant(v interface{}) (interface{}, error) {
+    switch v := v.(type) {
+    case int64:
+        return int64Word(v), nil
+    case *uint256.Int:
+        return new(uint256.Int).Set(v), nil
+    case string, bool, []byte:
+        if valueSize(v) > MaxValueSize {
+            return nil, fmt.Errorf("%w: %d byte constant", ErrMemoryLimit, valueSize(v))
+        }
+        return v, nil
+    default:
+        return nil, fmt.Errorf("%w: unsupported constant %v (%T)", ErrBadOperand, v, v)
+    }
+}
+
+// int64Word converts v to a two's complement word
+func int64Word(v int64) *uint256.Int {
+    w := uint256.NewInt(uint64(v))
+    if v < 0 {
+        w.Neg(uint256.NewInt(uint64(-v)))
+    }
+    return w
+}
+
+// arithmetic applies a binary arithmetic opcode; CONCAT joins two strings
+// or two byte slices
+func arithmetic(op OpCode, a, b interface{}) (interface{}, error) {
+    if op == CONCAT {
+        return concat(a, b)
+    }
+
+    x, xOk := a.(*uint256.Int)
+    y, yOk := b.(*uint256.Int)
+    if !xOk || !yOk {
+        return nil, fmt.Errorf("%w: cannot apply %s to %T and %T", ErrTypeMismatch, op, a, b)
+    }
+
+    z := new(uint256.Int)
+    overflow := false
+    switch op {
+    case ADD:
+        _, overflow = z.AddOverflow(x, y)
+    case SUB:
+        _, overflow = z.SubOverflow(x, y)
+    case MUL:
+        _, overflow = z.MulOverflow(x, y)
+    case WADD:
+        z.Add(x, y)
+    case WSUB:
+        z.Sub(x, y)
+    case WMUL:
+        z.Mul(x, y)
+    case DIV, MOD, SDIV, SMOD:
+        if y.IsZero() {
+            return nil, ErrDivisionByZero
+        }
+        switch op {
+        case DIV:
+            z.Div(x, y)
+        case MOD:
+            z.Mod(x, y)
+        case SDIV:
+            overflow = x.Eq(minInt256) && y.Eq(minusOne)
+            z.SDiv(x, y)
+        case SMOD:
+            z.SMod(x, y)
+        }
+    }
+    if overflow {
+        return nil, fmt.Errorf("%w in %s", ErrOverflow, op)
+    }
+    return z, nil
+}
+
+func concat(a, b interface{}) (interface{}, error) {
+    if valueSize(a)+valueSize(b) > MaxValueSize {
+        return nil, fmt.Errorf("%w: %d byte value", ErrMemoryLimit, valueSize(a)+valueSize(b))
+    }
+    switch x := a.(type) {
+    case string:
+        if y, ok := b.(string); ok {
+            return x + y, nil
+        }
+    case []byte:
+        if y, ok := b.([]byte); ok {
+            return append(append([]byte(nil), x...), y...), nil
+        }
+    }
+    return nil, fmt.Errorf("%w: cannot concatenate %T and %T", ErrTypeMismatch, a, b)
+}
+
+// compare applies a comparison to two values of the same type. Integers
+// and strings are ordered; bools and bytes only support EQ and NE.
+func compare(op OpCode, a, b interface{}) (bool, error) {
+    var c int
+    switch x := a.(type) {
+    case *uint256.Int:
+        y, ok := b.(*uint256.Int)
+        if !ok {
+            return false, fmt.Errorf("%w: cannot compare %T and %T", ErrTypeMismatch, a, b)
+        }
+        switch op {
+        case SLT, SGT, SLE, SGE:
+            c = signedCmp(x, y)
+        default:
+            c = x.Cmp(y)
+        }
+    case string:
+        y, ok := b.(string)
+        if !ok {
+            return false, fmt.Errorf("%w: cannot compare %T and %T", ErrTypeMismatch, a, b)
+        }
+        c = compareStrings(x, y)
+    case bool, []byte:
+        if op != EQ && op != NE {
+            return false, fmt.Errorf("%w: %T values are not ordered", ErrTypeMismatch, a)
+        }
+        var equal bool
+        switch y := b.(type) {
+        case bool:
+            xb, ok := x.(bool)
+            equal = ok && xb == y
+        case []byte:
+            xb, ok := x.([]byte)
+            equal = ok && bytes.Equal(xb, y)
+        default:
+            return false, fmt.Errorf("%w: cannot compare %T and %T", ErrTypeMismatch, a, b)
+        }
+        return equal == (op == EQ), nil
+    default:
+        return false, fmt.Errorf("%w: cannot compare %T and %T", ErrTypeMismatch, a, b)
+    }
+
+    switch op {
+    case LT, SLT:
+        return c < 0, nil
+    case GT, SGT:
+        return c > 0, nil
+    case LE, SLE:
+        return c <= 0, nil
+    case GE, SGE:
+        return c >= 0, nil
+    case EQ:
+        return c == 0, nil
+    default:
+        return c != 0, nil
+    }
+}
+
+// signedCmp compares two words as two's complement integers
+func signedCmp(x, y *uint256.Int) int {
+    switch {
+    case x.Slt(y):
+        return -1
+    case x.Sgt(y):
+        return 1
+    }
+    return 0
+}
+
+func compareStrings(a, b string) int {
+    switch {
+    case a < b:
+        return -1
+    case a > b:
+        return 1
+    }
+    return 0
+}
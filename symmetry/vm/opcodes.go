@@ -0,0 +1,186 @@
+package vm
+
+import "fmt"
+
+// OpCode is a single-byte Symmetry VM instruction
+type OpCode byte
+
+const (
+    STOP OpCode = 0x00
+
+    ADD OpCode = 0x01
+    SUB OpCode = 0x02
+    MUL OpCode = 0x03
+    DIV OpCode = 0x04
+    MOD OpCode = 0x05
+    // Wrapping arithmetic, modulo 2^256
+    WADD OpCode = 0x06
+    WSUB OpCode = 0x07
+    WMUL OpCode = 0x08
+    // Signed (two's complement) division
+    SDIV   OpCode = 0x09
+    SMOD   OpCode = 0x0a
+    NEG    OpCode = 0x0b
+    CONCAT OpCode = 0x0c
+
+    LT  OpCode = 0x10
+    GT  OpCode = 0x11
+    LE  OpCode = 0x12
+    GE  OpCode = 0x13
+    EQ  OpCode = 0x14
+    NE  OpCode = 0x15
+    AND OpCode = 0x16
+    OR  OpCode = 0x17
+    NOT OpCode = 0x18
+    SLT OpCode = 0x19
+    SGT OpCode = 0x1a
+    SLE OpCode = 0x1b
+    SGE OpCode = 0x1c
+
+    // Call and block context, read from the host
+    ADDRESS   OpCode = 0x30
+    BALANCE   OpCode = 0x31
+    CALLER    OpCode = 0x32
+    INPUT     OpCode = 0x33
+    NUMBER    OpCode = 0x40
+    TIMESTAMP OpCode = 0x41
+
+    POP    OpCode = 0x50
+    LOAD   OpCode = 0x54
+    STORE  OpCode = 0x55
+    JMP    OpCode = 0x56
+    JMPIF  OpCode = 0x57
+    CALL   OpCode = 0x58
+    RET    OpCode = 0x59
+    LLOAD  OpCode = 0x5a
+    LSTORE OpCode = 0x5b
+    // Contract storage, kept by the host
+    SLOAD  OpCode = 0x5c
+    SSTORE OpCode = 0x5d
+
+    PUSH OpCode = 0x60
+
+    DUP  OpCode = 0x80
+    SWAP OpCode = 0x90
+
+    LOG OpCode = 0xa0
+
+    CALLCONTRACT OpCode = 0xf1
+    TRANSFER     OpCode = 0xf2
+    RETURN       OpCode = 0xf3
+    NATIVE       OpCode = 0xf4
+    STATICCALL   OpCode = 0xfa
+    REVERT       OpCode = 0xfd
+)
+
+// OperandKind describes the immediate operand an opcode carries
+type OperandKind byte
+
+const (
+    // OperandNone means the opcode has no operand
+    OperandNone OperandKind = iota
+    // OperandValue is a typed constant: an integer, string, bool or bytes
+    OperandValue
+    // OperandName is a variable or precompile name
+    OperandName
+    // OperandTarget is a code offset, 4 bytes big-endian
+    OperandTarget
+)
+
+type opInfo struct {
+    name    string
+    operand OperandKind
+    // pops and pushes give the opcode's effect on the stack. CALL and RET
+    // also run or leave a function, whose effect the verifier works out,
+    // and NATIVE pops as many values as its precompile takes.
+    pops   int
+    pushes int
+}
+
+var opTable = map[OpCode]opInfo{
+    STOP:         {"STOP", OperandNone, 0, 0},
+    ADD:          {"ADD", OperandNone, 2, 1},
+    SUB:          {"SUB", OperandNone, 2, 1},
+    MUL:          {"MUL", OperandNone, 2, 1},
+    DIV:          {"DIV", OperandNone, 2, 1},
+    MOD:          {"MOD", OperandNone, 2, 1},
+    WADD:         {"WADD", OperandNone, 2, 1},
+    WSUB:         {"WSUB", OperandNone, 2, 1},
+    WMUL:         {"WMUL", OperandNone, 2, 1},
+    SDIV:         {"SDIV", OperandNone, 2, 1},
+    SMOD:         {"SMOD", OperandNone, 2, 1},
+    NEG:          {"NEG", OperandNone, 1, 1},
+    CONCAT:       {"CONCAT", OperandNone, 2, 1},
+    LT:           {"LT", OperandNone, 2, 1},
+    GT:           {"GT", OperandNone, 2, 1},
+    LE:           {"LE", OperandNone, 2, 1},
+    GE:           {"GE", OperandNone, 2, 1},
+    EQ:           {"EQ", OperandNone, 2, 1},
+    NE:           {"NE", OperandNone, 2, 1},
+    AND:          {"AND", OperandNone, 2, 1},
+    OR:           {"OR", OperandNone, 2, 1},
+    NOT:          {"NOT", OperandNone, 1, 1},
+    SLT:          {"SLT", OperandNone, 2, 1},
+    SGT:          {"SGT", OperandNone, 2, 1},
+    SLE:          {"SLE", OperandNone, 2, 1},
+    SGE:          {"SGE", OperandNone, 2, 1},
+    ADDRESS:      {"ADDRESS", OperandNone, 0, 1},
+    BALANCE:      {"BALANCE", OperandNone, 1, 1},
+    CALLER:       {"CALLER", OperandNone, 0, 1},
+    INPUT:        {"INPUT", OperandNone, 0, 1},
+    NUMBER:       {"NUMBER", OperandNone, 0, 1},
+    TIMESTAMP:    {"TIMESTAMP", OperandNone, 0, 1},
+    POP:          {"POP", OperandNone, 1, 0},
+    LOAD:         {"LOAD", OperandName, 0, 1},
+    STORE:        {"STORE", OperandName, 1, 0},
+    JMP:          {"JMP", OperandTarget, 0, 0},
+    JMPIF:        {"JMPIF", OperandTarget, 1, 0},
+    CALL:         {"CALL", OperandTarget, 0, 0},
+    RET:          {"RET", OperandNone, 0, 0},
+    LLOAD:        {"LLOAD", OperandName, 0, 1},
+    LSTORE:       {"LSTORE", OperandName, 1, 0},
+    SLOAD:        {"SLOAD", OperandNone, 1, 1},
+    SSTORE:       {"SSTORE", OperandNone, 2, 0},
+    PUSH:         {"PUSH", OperandValue, 0, 1},
+    DUP:          {"DUP", OperandNone, 1, 2},
+    SWAP:         {"SWAP", OperandNone, 2, 2},
+    LOG:          {"LOG", OperandNone, 2, 0},
+    CALLCONTRACT: {"CALLCONTRACT", OperandNone, 4, 2},
+    TRANSFER:     {"TRANSFER", OperandNone, 2, 0},
+    RETURN:       {"RETURN", OperandNone, 1, 0},
+    NATIVE:       {"NATIVE", OperandName, 0, 1},
+    STATICCALL:   {"STATICCALL", OperandNone, 3, 2},
+    REVERT:       {"REVERT", OperandNone, 1, 0},
+}
+
+var opNames = func() map[string]OpCode {
+    names := make(map[string]OpCode, len(opTable))
+    for op, info := range opTable {
+        names[info.name] = op
+    }
+    return names
+}()
+
+func (op OpCode) String() string {
+    if info, ok := opTable[op]; ok {
+        return info.name
+    }
+    return fmt.Sprintf("OpCode(0x%02x)", byte(op))
+}
+
+// Valid reports whether op is a defined opcode
+func (op OpCode) Valid() bool {
+    _, ok := opTable[op]
+    return ok
+}
+
+// Operand returns the kind of operand op carries
+func (op OpCode) Operand() OperandKind {
+    return opTable[op].operand
+}
+
+// ParseOpCode returns the opcode with the given mnemonic
+func ParseOpCode(name string) (OpCode, bool) {
+    op, ok := opNames[name]
+    return op, ok
+}
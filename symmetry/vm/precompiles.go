@@ -0,0 +1,219 @@
+package vm
+
+import (
+    "bytes"
+    "crypto/ed25519"
+    "crypto/sha256"
+    "errors"
+    "fmt"
+    "sort"
+
+    "github.com/decred/dcrd/dcrec/secp256k1/v4"
+    "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+    "github.com/holiman/uint256"
+    "golang.org/x/crypto/sha3"
+)
+
+// ErrUnknownPrecompile is returned for a NATIVE instruction naming no
+// registered precompile
+var ErrUnknownPrecompile = errors.New("unknown precompile")
+
+// Precompile is a function implemented in Go that contracts run with
+// NATIVE <name>. It pops Args values, bottom first as for any other
+// opcode, and pushes its result. Gas is the fixed price of a run; if
+// PerWord is set, each 32 bytes of string or byte arguments also costs
+// GasPerWord, for precompiles whose work grows with their input.
+type Precompile struct {
+    Name    string
+    Args    int
+    Gas     uint64
+    PerWord bool
+    Run     func(args []interface{}) (interface{}, error)
+}
+
+// Precompile gas costs
+const (
+    GasSHA256       uint64 = 60
+    GasKeccak256    uint64 = 60
+    GasEd25519      uint64 = 2000
+    GasSecp256k1    uint64 = 3000
+    GasMerkleVerify uint64 = 1000
+)
+
+// MaxMerkleDepth is the longest Merkle proof merkleverify accepts
+const MaxMerkleDepth = 32
+
+var precompiles = make(map[string]*Precompile)
+
+// RegisterPrecompile makes p available to NATIVE. It panics if the name
+// is taken, since that can only be a programming error.
+func RegisterPrecompile(p *Precompile) {
+    if _, exists := precompiles[p.Name]; exists {
+        panic("vm: precompile " + p.Name + " registered twice")
+    }
+    precompiles[p.Name] = p
+}
+
+// LookupPrecompile returns the precompile registered as name
+func LookupPrecompile(name string) (*Precompile, bool) {
+    p, ok := precompiles[name]
+    return p, ok
+}
+
+// Precompiles returns the names of the registered precompiles in order
+func Precompiles() []string {
+    names := make([]string, 0, len(precompiles))
+    for name := range precompiles {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+func init() {
+    // sha256 data -> 32 byte digest, the digest utils.HashString hex encodes
+    RegisterPrecompile(&Precompile{Name: "sha256", Args: 1, Gas: GasSHA256, PerWord: true, Run: runSHA256})
+    // keccak256 data -> 32 byte digest, as used by Ethereum
+    RegisterPrecompile(&Precompile{Name: "keccak256", Args: 1, Gas: GasKeccak256, PerWord: true, Run: runKeccak256})
+    // ed25519verify publicKey message signature -> bool; the message is
+    // hashed, so it is charged per word like the hashes
+    RegisterPrecompile(&Precompile{Name: "ed25519verify", Args: 3, Gas: GasEd25519, PerWord: true, Run: runEd25519Verify})
+    // secp256k1verify publicKey hash signature -> bool
+    RegisterPrecompile(&Precompile{Name: "secp256k1verify", Args: 3, Gas: GasSecp256k1, Run: runSecp256k1Verify})
+    // merkleverify leaf proof index root -> bool
+    RegisterPrecompile(&Precompile{Name: "merkleverify", Args: 4, Gas: GasMerkleVerify, Run: runMerkleVerify})
+}
+
+// executeNative runs the precompile named by a NATIVE instruction
+func (vm *VM) executeNative(instruction Instruction) error {
+    name := instruction.Operand.(string)
+    p, ok := LookupPrecompile(name)
+    if !ok {
+        return fmt.Errorf("%w %s", ErrUnknownPrecompile, name)
+    }
+    args, err := vm.popN(p.Args)
+    if err != nil {
+        return err
+    }
+    result, err := p.Run(args)
+    if err != nil {
+        return fmt.Errorf("%s: %w", name, err)
+    }
+    vm.push(result)
+    return nil
+}
+
+// data returns the bytes of a string or byte value
+func data(v interface{}) ([]byte, error) {
+    switch v := v.(type) {
+    case []byte:
+        return v, nil
+    case string:
+        return []byte(v), nil
+    }
+    return nil, fmt.Errorf("%w: %T is not bytes or a string", ErrTypeMismatch, v)
+}
+
+func runSHA256(args []interface{}) (interface{}, error) {
+    b, err := data(args[0])
+    if err != nil {
+        return nil, err
+    }
+    sum := sha256.Sum256(b)
+    return sum[:], nil
+}
+
+func runKeccak256(args []interface{}) (interface{}, error) {
+    b, err := data(args[0])
+    if err != nil {
+        return nil, err
+    }
+    h := sha3.NewLegacyKeccak256()
+    h.Write(b)
+    return h.Sum(nil), nil
+}
+
+// Signature checks return false for keys and signatures that don't parse,
+// so that contracts can treat every bad signature alike
+func runEd25519Verify(args []interface{}) (interface{}, error) {
+    key, msg, sig, err := signatureArgs(args)
+    if err != nil {
+        return nil, err
+    }
+    if len(key) != ed25519.PublicKeySize || len(sig) != ed25519.SignatureSize {
+        return false, nil
+    }
+    return ed25519.Verify(ed25519.PublicKey(key), msg, sig), nil
+}
+
+// runSecp256k1Verify checks an ECDSA signature given as 64 bytes, r then
+// s, over a 32 byte hash, with a compressed or uncompressed public key
+func runSecp256k1Verify(args []interface{}) (interface{}, error) {
+    key, hash, sig, err := signatureArgs(args)
+    if err != nil {
+        return nil, err
+    }
+    if len(hash) != 32 || len(sig) != 64 {
+        return false, nil
+    }
+    pub, err := secp256k1.ParsePubKey(key)
+    if err != nil {
+        return false, nil
+    }
+    var r, s secp256k1.ModNScalar
+    if r.SetByteSlice(sig[:32]) || s.SetByteSlice(sig[32:]) || r.IsZero() || s.IsZero() {
+        return false, nil
+    }
+    return ecdsa.NewSignature(&r, &s).Verify(hash, pub), nil
+}
+
+func signatureArgs(args []interface{}) ([]byte, []byte, []byte, error) {
+    key, keyOk := args[0].([]byte)
+    sig, sigOk := args[2].([]byte)
+    if !keyOk || !sigOk {
+        return nil, nil, nil, fmt.Errorf("%w: key and signature must be bytes", ErrTypeMismatch)
+    }
+    msg, err := data(args[1])
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    return key, msg, sig, nil
+}
+
+// runMerkleVerify checks that leaf, a 32 byte hash, is at position index
+// of the tree with the given root. The proof is the sibling hashes from
+// the leaf up, concatenated; parents are sha256(left || right), and bit i
+// of index is set if the node at level i is a right child.
+func runMerkleVerify(args []interface{}) (interface{}, error) {
+    leaf, leafOk := args[0].([]byte)
+    proof, proofOk := args[1].([]byte)
+    index, indexOk := args[2].(*uint256.Int)
+    root, rootOk := args[3].([]byte)
+    if !leafOk || !proofOk || !indexOk || !rootOk {
+        return nil, fmt.Errorf("%w: merkleverify takes bytes leaf, bytes proof, integer index, bytes root", ErrTypeMismatch)
+    }
+    depth := len(proof) / 32
+    if len(leaf) != 32 || len(root) != 32 || len(proof)%32 != 0 || depth > MaxMerkleDepth {
+        return false, nil
+    }
+    if !index.IsUint64() || index.Uint64()>>depth != 0 {
+        return false, nil
+    }
+
+    node := leaf
+    position := index.Uint64()
+    for i := 0; i < depth; i++ {
+        sibling := proof[i*32 : (i+1)*32]
+        h := sha256.New()
+        if position&1 == 1 {
+            h.Write(sibling)
+            h.Write(node)
+        } else {
+            h.Write(node)
+            h.Write(sibling)
+        }
+        node = h.Sum(nil)
+        position >>= 1
+    }
+    return bytes.Equal(node, root), nil
+}
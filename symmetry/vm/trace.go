@@ -0,0 +1,138 @@
+package vm
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "strconv"
+
+    "github.com/holiman/uint256"
+)
+
+// Step describes one executed instruction
+type Step struct {
+    PC      int
+    Op      OpCode
+    Operand interface{}
+    // Gas is the gas left before the instruction and Cost what it was
+    // charged
+    Gas  uint64
+    Cost uint64
+    // Depth is the number of active function calls
+    Depth int
+    // Stack is the stack after the instruction, bottom first
+    Stack []interface{}
+    // Memory holds the variables the instruction stored
+    Memory map[string]interface{}
+    Err    error
+}
+
+// Tracer is called by the VM as it runs. OnStep gets every instruction,
+// including one that fails; OnEnd gets the outcome of the run.
+type Tracer interface {
+    OnStep(step *Step)
+    OnEnd(gasUsed uint64, err error)
+}
+
+// SetTracer attaches a tracer, or detaches it if tracer is nil
+func (vm *VM) SetTracer(tracer Tracer) {
+    vm.tracer = tracer
+}
+
+// JSONTracer writes a trace as JSON lines: one object per instruction and
+// a last one with the gas used and error
+type JSONTracer struct {
+    enc *json.Encoder
+    err error
+}
+
+func NewJSONTracer(w io.Writer) *JSONTracer {
+    return &JSONTracer{enc: json.NewEncoder(w)}
+}
+
+type jsonStep struct {
+    PC      int               `json:"pc"`
+    Op      string            `json:"op"`
+    Operand string            `json:"operand,omitempty"`
+    Gas     uint64            `json:"gas"`
+    Cost    uint64            `json:"gasCost"`
+    Depth   int               `json:"depth"`
+    Stack   []string          `json:"stack"`
+    Memory  map[string]string `json:"memory,omitempty"`
+    Error   string            `json:"error,omitempty"`
+}
+
+type jsonEnd struct {
+    GasUsed uint64 `json:"gasUsed"`
+    Error   string `json:"error,omitempty"`
+}
+
+func (t *JSONTracer) OnStep(step *Step) {
+    out := jsonStep{
+        PC:    step.PC,
+        Op:    step.Op.String(),
+        Gas:   step.Gas,
+        Cost:  step.Cost,
+        Depth: step.Depth,
+        Stack: make([]string, len(step.Stack)),
+    }
+    if step.Operand != nil {
+        out.Operand = FormatValue(step.Operand)
+    }
+    for i, v := range step.Stack {
+        out.Stack[i] = FormatValue(v)
+    }
+    if len(step.Memory) > 0 {
+        out.Memory = make(map[string]string, len(step.Memory))
+        for k, v := range step.Memory {
+            out.Memory[k] = FormatValue(v)
+        }
+    }
+    if step.Err != nil {
+        out.Error = step.Err.Error()
+    }
+    t.write(out)
+}
+
+func (t *JSONTracer) OnEnd(gasUsed uint64, err error) {
+    out := jsonEnd{GasUsed: gasUsed}
+    if err != nil {
+        out.Error = err.Error()
+    }
+    t.write(out)
+}
+
+func (t *JSONTracer) write(v interface{}) {
+    if t.err == nil {
+        t.err = t.enc.Encode(v)
+    }
+}
+
+// Err returns the first error writing the trace
+func (t *JSONTracer) Err() error {
+    return t.err
+}
+
+// FormatValue renders a VM value the way traces and the debugger show it:
+// integers in decimal, strings quoted, bytes as 0x-prefixed hex and code
+// offsets as @offset
+func FormatValue(v interface{}) string {
+    switch v := v.(type) {
+    case *uint256.Int:
+        return v.Dec()
+    case int64:
+        return strconv.FormatInt(v, 10)
+    case string:
+        return strconv.Quote(v)
+    case bool:
+        return strconv.FormatBool(v)
+    case []byte:
+        return "0x" + hex.EncodeToString(v)
+    case uint32:
+        return "@" + strconv.FormatUint(uint64(v), 10)
+    default:
+        return fmt.Sprintf("%v", v)
+    }
+}
+
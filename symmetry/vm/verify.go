@@ -0,0 +1,224 @@
+package vm
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+)
+
+// ErrStackHeight is returned by the verifier when the stack height at an
+// instruction depends on the path taken to reach it
+var ErrStackHeight = errors.New("inconsistent stack height")
+
+// Verify checks bytecode before it is deployed: it must be at most
+// MaxCodeSize bytes and pass VerifyProgram.
+func Verify(code []byte) error {
+    if len(code) > MaxCodeSize {
+        return fmt.Errorf("%w: %d bytes, at most %d", ErrCodeSize, len(code), MaxCodeSize)
+    }
+    program, err := Decode(code)
+    if err != nil {
+        return err
+    }
+    return VerifyProgram(program)
+}
+
+// VerifyProgram checks that every instruction has a valid opcode and
+// operand, that jumps and calls land on instructions, and that the stack
+// height at each instruction is the same on every path reaching it and
+// never drops below zero. Programs that pass can't underflow the stack or
+// jump out of the code at run time.
+//
+// Functions are checked on their own, starting at height zero. A function
+// may take values its caller pushed, and every RET must leave the same
+// height so that each CALL has a single known effect on its caller's
+// stack. Functions that never return end the path of whoever calls them.
+func VerifyProgram(program []Instruction) error {
+    var buf bytes.Buffer
+    for i, ins := range program {
+        buf.Reset()
+        if err := encodeInstruction(&buf, ins); err != nil {
+            return fmt.Errorf("%s at %d: %w", ins.OpCode, i, err)
+        }
+    }
+
+    v := &verifier{program: program, functions: make(map[int]*function)}
+    offsets, _ := codeOffsets(program)
+    v.offsets = make(map[uint32]int, len(offsets))
+    for i, offset := range offsets {
+        v.offsets[uint32(offset)] = i
+    }
+
+    var entries []int
+    for i, ins := range program {
+        if ins.OpCode.Operand() != OperandTarget {
+            continue
+        }
+        target, ok := v.offsets[ins.Operand.(uint32)]
+        if !ok {
+            return fmt.Errorf("%s at %d: %w: %d", ins.OpCode, i, ErrInvalidJump, ins.Operand)
+        }
+        if ins.OpCode == CALL && v.functions[target] == nil {
+            v.functions[target] = &function{}
+            entries = append(entries, target)
+        }
+    }
+
+    // Work out what each function does to the stack. A function's effect
+    // is only known once a path through it returns, which for recursive
+    // functions needs the effect of the function itself, so keep going
+    // until nothing changes. When a function's effect changes, only the
+    // functions that call it need another look.
+    callers := make(map[int]map[int]bool)
+    queued := make(map[int]bool, len(entries))
+    work := append([]int(nil), entries...)
+    for _, entry := range entries {
+        queued[entry] = true
+    }
+    for len(work) > 0 {
+        entry := work[0]
+        work = work[1:]
+        queued[entry] = false
+
+        fn, callees, err := v.analyze(entry, false)
+        if err != nil {
+            return err
+        }
+        for _, callee := range callees {
+            if callers[callee] == nil {
+                callers[callee] = make(map[int]bool)
+            }
+            callers[callee][entry] = true
+        }
+        if *fn == *v.functions[entry] {
+            continue
+        }
+        *v.functions[entry] = *fn
+        for caller := range callers[entry] {
+            if !queued[caller] {
+                queued[caller] = true
+                work = append(work, caller)
+            }
+        }
+    }
+
+    _, _, err := v.analyze(0, true)
+    return err
+}
+
+// function is what a call does to its caller's stack
+type function struct {
+    returns bool
+    // needs is how many of the caller's values the function pops
+    needs int
+    // delta is the change in stack height when it returns
+    delta int
+}
+
+type verifier struct {
+    program   []Instruction
+    offsets   map[uint32]int
+    functions map[int]*function
+}
+
+// analyze walks every path from entry and returns the effect of the code
+// as a function, together with the functions called on the way. The top
+// level starts on an empty stack, and RET there ends the program.
+func (v *verifier) analyze(entry int, top bool) (*function, []int, error) {
+    if entry >= len(v.program) {
+        return &function{}, nil, nil
+    }
+
+    fn := &function{}
+    var callees []int
+    heights := map[int]int{entry: 0}
+    work := []int{entry}
+
+    flow := func(from, to, height int) error {
+        if to >= len(v.program) {
+            // Running off the end stops the program
+            return nil
+        }
+        if seen, ok := heights[to]; ok {
+            if seen != height {
+                return fmt.Errorf("%s at %d: %w: %d here, %d on another path to %d",
+                    v.program[from].OpCode, from, ErrStackHeight, height, seen, to)
+            }
+            return nil
+        }
+        heights[to] = height
+        work = append(work, to)
+        return nil
+    }
+
+    for len(work) > 0 {
+        i := work[len(work)-1]
+        work = work[:len(work)-1]
+        ins := v.program[i]
+        height := heights[i]
+        info := opTable[ins.OpCode]
+
+        needs := info.pops
+        var callee *function
+        switch ins.OpCode {
+        case CALL:
+            target := v.offsets[ins.Operand.(uint32)]
+            callee = v.functions[target]
+            callees = append(callees, target)
+            needs = callee.needs
+        case NATIVE:
+            p, ok := LookupPrecompile(ins.Operand.(string))
+            if !ok {
+                return nil, nil, fmt.Errorf("%s at %d: %w %s", ins.OpCode, i, ErrUnknownPrecompile, ins.Operand)
+            }
+            needs = p.Args
+        }
+        if height-needs < -fn.needs {
+            if top {
+                return nil, nil, fmt.Errorf("%s at %d: %w", ins.OpCode, i, ErrStackUnderflow)
+            }
+            fn.needs = needs - height
+            if fn.needs > MaxStackSize {
+                return nil, nil, fmt.Errorf("%s at %d: %w", ins.OpCode, i, ErrStackOverflow)
+            }
+        }
+        next := height - needs + info.pushes
+        if ins.OpCode == CALL {
+            next = height + callee.delta
+        }
+        if top && next > MaxStackSize {
+            return nil, nil, fmt.Errorf("%s at %d: %w", ins.OpCode, i, ErrStackOverflow)
+        }
+
+        var err error
+        switch ins.OpCode {
+        case STOP, RETURN, REVERT:
+        case RET:
+            if top {
+                break
+            }
+            if fn.returns && fn.delta != height {
+                return nil, nil, fmt.Errorf("%s at %d: %w: returns with %d, %d on another path",
+                    ins.OpCode, i, ErrStackHeight, height, fn.delta)
+            }
+            fn.returns, fn.delta = true, height
+        case JMP:
+            err = flow(i, v.offsets[ins.Operand.(uint32)], next)
+        case JMPIF:
+            if err = flow(i, v.offsets[ins.Operand.(uint32)], next); err == nil {
+                err = flow(i, i+1, next)
+            }
+        case CALL:
+            // Until the function is known to return, the path stops here
+            if callee.returns {
+                err = flow(i, i+1, next)
+            }
+        default:
+            err = flow(i, i+1, next)
+        }
+        if err != nil {
+            return nil, nil, err
+        }
+    }
+    return fn, callees, nil
+}
@@ -1,19 +1,92 @@
 package vm
 
 import (
+    "errors"
     "fmt"
-    "strconv"
+
+    "github.com/holiman/uint256"
+)
+
+var (
+    ErrStackUnderflow  = errors.New("stack underflow")
+    ErrDivisionByZero  = errors.New("division by zero")
+    ErrTypeMismatch    = errors.New("type mismatch")
+    ErrOverflow        = errors.New("integer overflow")
+    ErrUnknownVariable = errors.New("unknown variable")
+    ErrInvalidJump     = errors.New("jump target is not an instruction boundary")
+    ErrCallDepth       = errors.New("maximum call depth exceeded")
+    ErrOutOfGas        = errors.New("out of gas")
+    ErrStackOverflow   = errors.New("stack overflow")
+    ErrMemoryLimit     = errors.New("memory limit exceeded")
+    ErrReverted        = errors.New("execution reverted")
 )
 
+// RevertError is returned by Run when the program executes REVERT. It
+// matches ErrReverted and carries the value the program reverted with.
+type RevertError struct {
+    Payload interface{}
+}
+
+func (e *RevertError) Error() string {
+    return fmt.Sprintf("%v: %v", ErrReverted, e.Payload)
+}
+
+func (e *RevertError) Is(target error) bool {
+    return target == ErrReverted
+}
+
+// MaxCallDepth is the deepest CALL nesting allowed
+const MaxCallDepth = 1024
+
 type VM struct {
     stack   []interface{}
     memory  map[string]interface{}
+    journal *Journal
     program []Instruction
     pc      int // Program counter
+    next    int // Instruction run after the current one
+
+    // offsets maps the code offset of each instruction to its index
+    offsets map[uint32]int
+    // verified is set once the program has passed VerifyProgram
+    verified bool
+
+    // Journal checkpoints opened by start for the current run
+    checkpoint     int
+    hostCheckpoint int
+    // frames is the call stack; the bottom frame belongs to the program's
+    // top level and is never popped by RET
+    frames []*frame
+
+    gasLimit uint64
+    gasUsed  uint64
+
+    host     Host
+    readOnly bool
+    input    interface{}
+    result   interface{}
+
+    tracer Tracer
+    // written collects the variables the traced instruction stores
+    written map[string]interface{}
 }
 
+// frame is a function activation: where RET continues and the function's
+// local variables
+type frame struct {
+    returnPC int
+    locals   map[string]interface{}
+}
+
+func newFrame(returnPC int) *frame {
+    return &frame{returnPC: returnPC, locals: make(map[string]interface{})}
+}
+
+// Instruction is a decoded opcode and its immediate operand, if any.
+// Value operands are int64, *uint256.Int, string, bool or []byte; PUSH
+// turns integers into 256-bit words.
 type Instruction struct {
-    OpCode  string
+    OpCode  OpCode
     Operand interface{}
 }
 
@@ -21,111 +94,381 @@ func NewVM() *VM {
     return &VM{
         stack:   make([]interface{}, 0),
         memory:  make(map[string]interface{}),
+        journal: NewJournal(),
         program: make([]Instruction, 0),
         pc:      0,
+        frames:  []*frame{newFrame(0)},
     }
 }
 
 func (vm *VM) LoadProgram(program []Instruction) {
     vm.program = program
     vm.pc = 0
+    vm.frames = []*frame{newFrame(len(program))}
+    vm.result = nil
+    vm.verified = false
+
+    offsets, _ := codeOffsets(program)
+    vm.offsets = make(map[uint32]int, len(offsets))
+    for i, offset := range offsets {
+        vm.offsets[uint32(offset)] = i
+    }
 }
 
-func (vm *VM) Run() error {
-    for vm.pc < len(vm.program) {
-        instruction := vm.program[vm.pc]
-        if err := vm.executeInstruction(instruction); err != nil {
+// LoadBytecode decodes code and loads it as the program
+func (vm *VM) LoadBytecode(code []byte) error {
+    program, err := Decode(code)
+    if err != nil {
+        return err
+    }
+    vm.LoadProgram(program)
+    return nil
+}
+
+// LoadVerified loads code that has already passed Verify, such as a
+// deployed contract, so that Run doesn't check it again
+func (vm *VM) LoadVerified(code []byte) error {
+    if err := vm.LoadBytecode(code); err != nil {
+        return err
+    }
+    vm.verified = true
+    return nil
+}
+
+// Run executes the loaded program with at most gasLimit gas and returns the
+// gas used. Changes to memory and to the host's state are committed only
+// if the program succeeds; if it fails or reverts they are all undone.
+// Running out of gas uses the whole limit.
+//
+// Every run starts from the first instruction with an empty stack and no
+// function calls in progress, so a program can be run again whether or
+// not the last run succeeded. Memory keeps what earlier successful runs
+// stored.
+func (vm *VM) Run(gasLimit uint64) (uint64, error) {
+    if err := vm.start(gasLimit); err != nil {
+        return 0, err
+    }
+    var err error
+    for err == nil && !vm.halted() {
+        err = vm.step()
+    }
+    return vm.finish(err)
+}
+
+// start verifies the program, resets the execution state and opens the
+// checkpoints finish closes
+func (vm *VM) start(gasLimit uint64) error {
+    if !vm.verified {
+        if err := VerifyProgram(vm.program); err != nil {
             return err
         }
-        vm.pc++
+        vm.verified = true
+    }
+    vm.pc = 0
+    vm.stack = vm.stack[:0]
+    vm.frames = []*frame{newFrame(len(vm.program))}
+    vm.result = nil
+    vm.gasLimit, vm.gasUsed = gasLimit, 0
+    vm.checkpoint = vm.journal.Checkpoint()
+    if vm.host != nil {
+        vm.hostCheckpoint = vm.host.Checkpoint()
+    }
+    return nil
+}
+
+// finish commits the run's changes, or reverts them if it failed, and
+// returns the gas used
+func (vm *VM) finish(err error) (uint64, error) {
+    if err != nil {
+        vm.journal.RevertTo(vm.checkpoint)
+        if vm.host != nil {
+            vm.host.RevertTo(vm.hostCheckpoint)
+        }
+        if errors.Is(err, ErrOutOfGas) {
+            vm.gasUsed = vm.gasLimit
+        }
+    } else {
+        vm.journal.Commit(vm.checkpoint)
+        if vm.host != nil {
+            vm.host.Commit(vm.hostCheckpoint)
+        }
+    }
+    if vm.tracer != nil {
+        vm.tracer.OnEnd(vm.gasUsed, err)
+    }
+    return vm.gasUsed, err
+}
+
+// halted reports whether the program has ended
+func (vm *VM) halted() bool {
+    return vm.pc >= len(vm.program)
+}
+
+// step charges for and runs the instruction at pc
+func (vm *VM) step() error {
+    instruction := vm.program[vm.pc]
+    var trace *Step
+    if vm.tracer != nil {
+        trace = &Step{
+            PC:      vm.pc,
+            Op:      instruction.OpCode,
+            Operand: instruction.Operand,
+            Gas:     vm.gasLimit - vm.gasUsed,
+            Depth:   len(vm.frames) - 1,
+        }
+        vm.written = make(map[string]interface{})
+        defer func() {
+            trace.Stack = append([]interface{}(nil), vm.stack...)
+            trace.Memory = vm.written
+            vm.written = nil
+            vm.tracer.OnStep(trace)
+        }()
+    }
+
+    err := vm.execute(instruction, trace)
+    if err != nil {
+        err = fmt.Errorf("%s at %d: %w", instruction.OpCode, vm.pc, err)
+        if trace != nil {
+            trace.Err = err
+        }
+        return err
     }
+    vm.pc = vm.next
     return nil
 }
 
+func (vm *VM) execute(instruction Instruction, trace *Step) error {
+    cost := vm.gasCost(instruction)
+    if trace != nil {
+        trace.Cost = cost
+    }
+    if cost > vm.gasLimit-vm.gasUsed {
+        return ErrOutOfGas
+    }
+    vm.gasUsed += cost
+
+    vm.next = vm.pc + 1
+    if err := vm.executeInstruction(instruction); err != nil {
+        return err
+    }
+    return vm.checkLimits()
+}
+
+// checkLimits enforces the stack and memory limits after an instruction.
+// No instruction adds more than one value, so checking afterwards is
+// enough to keep within bounds.
+func (vm *VM) checkLimits() error {
+    switch {
+    case len(vm.stack) > MaxStackSize:
+        return ErrStackOverflow
+    case len(vm.memory) > MaxMemorySlots:
+        return fmt.Errorf("%w: more than %d variables", ErrMemoryLimit, MaxMemorySlots)
+    case len(vm.frame().locals) > MaxLocals:
+        return fmt.Errorf("%w: more than %d locals", ErrMemoryLimit, MaxLocals)
+    }
+    return nil
+}
+
+// target returns the instruction index a jump or call continues at. The
+// verifier has checked that it is one.
+func (vm *VM) target(ins Instruction) int {
+    return vm.offsets[ins.Operand.(uint32)]
+}
+
+func (vm *VM) frame() *frame {
+    return vm.frames[len(vm.frames)-1]
+}
+
 func (vm *VM) executeInstruction(instruction Instruction) error {
-    switch instruction.OpCode {
-    case "PUSH":
-        vm.stack = append(vm.stack, instruction.Operand)
-    case "POP":
-        if len(vm.stack) == 0 {
-            return fmt.Errorf("stack underflow")
-        }
-        vm.stack = vm.stack[:len(vm.stack)-1]
-    case "ADD":
+    switch op := instruction.OpCode; op {
+    case STOP:
+        vm.next = len(vm.program)
+    case PUSH:
+        value, err := Constant(instruction.Operand)
+        if err != nil {
+            return err
+        }
+        vm.push(value)
+    case POP:
+        _, err := vm.popN(1)
+        return err
+    case DUP:
+        if len(vm.stack) < 1 {
+            return ErrStackUnderflow
+        }
+        vm.push(vm.stack[len(vm.stack)-1])
+    case SWAP:
         if len(vm.stack) < 2 {
-            return fmt.Errorf("not enough operands for ADD")
+            return ErrStackUnderflow
         }
-        b := vm.pop()
-        a := vm.pop()
-        result, err := vm.add(a, b)
+        n := len(vm.stack)
+        vm.stack[n-1], vm.stack[n-2] = vm.stack[n-2], vm.stack[n-1]
+    case ADD, SUB, MUL, DIV, MOD, WADD, WSUB, WMUL, SDIV, SMOD, CONCAT:
+        args, err := vm.popN(2)
         if err != nil {
             return err
         }
-        vm.stack = append(vm.stack, result)
-    case "STORE":
-        if len(vm.stack) < 1 {
-            return fmt.Errorf("not enough operands for STORE")
+        result, err := arithmetic(op, args[0], args[1])
+        if err != nil {
+            return err
+        }
+        vm.push(result)
+    case NEG:
+        args, err := vm.popN(1)
+        if err != nil {
+            return err
+        }
+        x, ok := args[0].(*uint256.Int)
+        if !ok {
+            return fmt.Errorf("%w: %T is not an integer", ErrTypeMismatch, args[0])
+        }
+        vm.push(new(uint256.Int).Neg(x))
+    case LT, GT, LE, GE, EQ, NE, SLT, SGT, SLE, SGE:
+        args, err := vm.popN(2)
+        if err != nil {
+            return err
+        }
+        result, err := compare(op, args[0], args[1])
+        if err != nil {
+            return err
+        }
+        vm.push(result)
+    case AND, OR:
+        args, err := vm.popN(2)
+        if err != nil {
+            return err
+        }
+        a, aOk := args[0].(bool)
+        b, bOk := args[1].(bool)
+        if !aOk || !bOk {
+            return fmt.Errorf("%w: %T and %T are not both bool", ErrTypeMismatch, args[0], args[1])
+        }
+        if op == AND {
+            vm.push(a && b)
+        } else {
+            vm.push(a || b)
+        }
+    case NOT:
+        args, err := vm.popN(1)
+        if err != nil {
+            return err
         }
-        value := vm.pop()
-        key, ok := instruction.Operand.(string)
+        a, ok := args[0].(bool)
         if !ok {
-            return fmt.Errorf("STORE operand must be a string")
+            return fmt.Errorf("%w: %T is not bool", ErrTypeMismatch, args[0])
+        }
+        vm.push(!a)
+    case JMP:
+        vm.next = vm.target(instruction)
+    case JMPIF:
+        args, err := vm.popN(1)
+        if err != nil {
+            return err
         }
-        vm.memory[key] = value
-    case "LOAD":
-        key, ok := instruction.Operand.(string)
+        cond, ok := args[0].(bool)
         if !ok {
-            return fmt.Errorf("LOAD operand must be a string")
+            return fmt.Errorf("%w: condition %T is not bool", ErrTypeMismatch, args[0])
+        }
+        if cond {
+            vm.next = vm.target(instruction)
+        }
+    case CALL:
+        if len(vm.frames) > MaxCallDepth {
+            return ErrCallDepth
+        }
+        vm.frames = append(vm.frames, newFrame(vm.next))
+        vm.next = vm.target(instruction)
+    case RET:
+        // Returning from the top level ends the program
+        vm.next = vm.frame().returnPC
+        if len(vm.frames) > 1 {
+            vm.frames = vm.frames[:len(vm.frames)-1]
+        }
+    case LSTORE:
+        args, err := vm.popN(1)
+        if err != nil {
+            return err
+        }
+        key := instruction.Operand.(string)
+        vm.frame().locals[key] = args[0]
+    case LLOAD:
+        key := instruction.Operand.(string)
+        value, exists := vm.frame().locals[key]
+        if !exists {
+            return fmt.Errorf("%w %s", ErrUnknownVariable, key)
+        }
+        vm.push(value)
+    case STORE:
+        args, err := vm.popN(1)
+        if err != nil {
+            return err
         }
+        key := instruction.Operand.(string)
+        vm.setMemory(key, args[0])
+    case LOAD:
+        key := instruction.Operand.(string)
         value, exists := vm.memory[key]
         if !exists {
-            return fmt.Errorf("variable %s not found", key)
+            return fmt.Errorf("%w %s", ErrUnknownVariable, key)
+        }
+        vm.push(value)
+    case INPUT:
+        if vm.input == nil {
+            vm.push([]byte{})
+        } else {
+            vm.push(vm.input)
+        }
+    case RETURN:
+        args, err := vm.popN(1)
+        if err != nil {
+            return err
+        }
+        vm.result = args[0]
+        vm.next = len(vm.program)
+    case REVERT:
+        args, err := vm.popN(1)
+        if err != nil {
+            return err
         }
-        vm.stack = append(vm.stack, value)
+        return &RevertError{Payload: args[0]}
+    case NATIVE:
+        return vm.executeNative(instruction)
+    case ADDRESS, CALLER, NUMBER, TIMESTAMP, BALANCE, TRANSFER, SLOAD, SSTORE, LOG, CALLCONTRACT, STATICCALL:
+        return vm.executeHost(instruction)
     default:
         return fmt.Errorf("unknown opcode: %s", instruction.OpCode)
     }
     return nil
 }
 
-func (vm *VM) pop() interface{} {
-    if len(vm.stack) == 0 {
-        return nil
-    }
-    value := vm.stack[len(vm.stack)-1]
-    vm.stack = vm.stack[:len(vm.stack)-1]
-    return value
+func (vm *VM) push(value interface{}) {
+    vm.stack = append(vm.stack, value)
 }
 
-func (vm *VM) add(a, b interface{}) (interface{}, error) {
-    // Try to convert operands to float64
-    aFloat, aErr := vm.toFloat64(a)
-    bFloat, bErr := vm.toFloat64(b)
-
-    if aErr == nil && bErr == nil {
-        return aFloat + bFloat, nil
-    }
-
-    // If not both numbers, try string concatenation
-    aStr, aOk := a.(string)
-    bStr, bOk := b.(string)
-    if aOk && bOk {
-        return aStr + bStr, nil
+// popN removes the top n values and returns them bottom first, so for a
+// binary operation args[0] is the left operand
+func (vm *VM) popN(n int) ([]interface{}, error) {
+    if len(vm.stack) < n {
+        return nil, ErrStackUnderflow
     }
-
-    return nil, fmt.Errorf("cannot add %v and %v", a, b)
+    args := append([]interface{}(nil), vm.stack[len(vm.stack)-n:]...)
+    vm.stack = vm.stack[:len(vm.stack)-n]
+    return args, nil
 }
 
-func (vm *VM) toFloat64(v interface{}) (float64, error) {
-    switch v := v.(type) {
-    case float64:
-        return v, nil
-    case int:
-        return float64(v), nil
-    case string:
-        return strconv.ParseFloat(v, 64)
-    default:
-        return 0, fmt.Errorf("cannot convert %v to float64", v)
+// setMemory writes a global variable and journals the write
+func (vm *VM) setMemory(key string, value interface{}) {
+    previous, existed := vm.memory[key]
+    vm.journal.Record(func() {
+        if existed {
+            vm.memory[key] = previous
+        } else {
+            delete(vm.memory, key)
+        }
+    })
+    vm.memory[key] = value
+    if vm.written != nil {
+        vm.written[key] = value
     }
 }
 
@@ -0,0 +1,169 @@
+package vm
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+
+    "github.com/holiman/uint256"
+)
+
+// countdown counts n down from 3 to 1, storing each value under "n" and
+// calling a function to decrement it
+var countdown = []Instruction{
+    {PUSH, int64(3)},
+    {STORE, "n"},
+    {PUSH, "n"}, // loop
+    {LOAD, "n"},
+    {SSTORE, nil},
+    {LOAD, "n"},
+    {CALL, uint32(0)}, // decrement
+    {DUP, nil},
+    {STORE, "n"},
+    {PUSH, int64(0)},
+    {GT, nil},
+    {JMPIF, uint32(0)}, // loop
+    {PUSH, "done"},
+    {RETURN, nil},
+    {PUSH, int64(1)}, // decrement
+    {SUB, nil},
+    {RET, nil},
+}
+
+// program returns countdown with its jump and call targets resolved
+func program(t *testing.T) []Instruction {
+    t.Helper()
+    prog := append([]Instruction(nil), countdown...)
+    offsets := Offsets(prog)
+    prog[6].Operand = uint32(offsets[14])
+    prog[11].Operand = uint32(offsets[2])
+    return prog
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+    max := new(uint256.Int).Not(new(uint256.Int))
+    prog := append(program(t),
+        Instruction{PUSH, int64(-42)},
+        Instruction{PUSH, max},
+        Instruction{PUSH, true},
+        Instruction{PUSH, []byte{0xc0, 0xff, 0xee}},
+        Instruction{NATIVE, "sha256"},
+    )
+
+    code, err := Encode(prog)
+    if err != nil {
+        t.Fatal(err)
+    }
+    decoded, err := Decode(code)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(decoded, prog) {
+        t.Fatalf("decoded %v, want %v", decoded, prog)
+    }
+}
+
+func TestVerifyRejects(t *testing.T) {
+    tests := []struct {
+        name    string
+        program []Instruction
+        want    error
+    }{
+        {"stack underflow", []Instruction{{PUSH, int64(1)}, {ADD, nil}}, ErrStackUnderflow},
+        {"jump into an instruction", []Instruction{{PUSH, int64(1)}, {JMP, uint32(1)}}, ErrInvalidJump},
+        {"jump past the end", []Instruction{{JMP, uint32(100)}}, ErrInvalidJump},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            code, err := Encode(tt.program)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if err := Verify(code); !errors.Is(err, tt.want) {
+                t.Fatalf("got %v, want %v", err, tt.want)
+            }
+        })
+    }
+
+    t.Run("oversized code", func(t *testing.T) {
+        code, err := Encode(make([]Instruction, MaxCodeSize))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := Verify(code); !errors.Is(err, ErrCodeSize) {
+            t.Fatalf("got %v, want %v", err, ErrCodeSize)
+        }
+    })
+
+    t.Run("valid program", func(t *testing.T) {
+        code, err := Encode(program(t))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := Verify(code); err != nil {
+            t.Fatal(err)
+        }
+    })
+}
+
+func TestRunOutOfGas(t *testing.T) {
+    host := NewMockHost("caller", "contract")
+    machine := NewVM()
+    machine.LoadProgram(program(t))
+    machine.SetHost(host)
+
+    // Enough gas for the first SSTORE but not the second
+    used, err := machine.Run(1000)
+    if !errors.Is(err, ErrOutOfGas) {
+        t.Fatalf("got %v, want %v", err, ErrOutOfGas)
+    }
+    if used != 1000 {
+        t.Errorf("used %d gas, want the whole limit of 1000", used)
+    }
+    if len(host.Storage["contract"]) != 0 {
+        t.Errorf("storage %v was kept after running out of gas", host.Storage["contract"])
+    }
+
+    _, err = machine.Run(1000000)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if machine.Result() != "done" {
+        t.Errorf("got result %v, want done", machine.Result())
+    }
+    want := map[string]interface{}{"n": int64Word(1)}
+    if !reflect.DeepEqual(host.Storage["contract"], want) {
+        t.Errorf("got storage %v, want %v", host.Storage["contract"], want)
+    }
+}
+
+func TestRevertRollsBackStorage(t *testing.T) {
+    host := NewMockHost("caller", "contract")
+    host.SetStorage("kept", int64Word(1))
+
+    machine := NewVM()
+    machine.LoadProgram([]Instruction{
+        {PUSH, "kept"},
+        {PUSH, int64(2)},
+        {SSTORE, nil},
+        {PUSH, "added"},
+        {PUSH, int64(3)},
+        {SSTORE, nil},
+        {PUSH, "no"},
+        {REVERT, nil},
+    })
+    machine.SetHost(host)
+
+    _, err := machine.Run(1000000)
+    var revert *RevertError
+    if !errors.As(err, &revert) || !errors.Is(err, ErrReverted) {
+        t.Fatalf("got %v, want a revert", err)
+    }
+    if revert.Payload != "no" {
+        t.Errorf("got revert payload %v, want no", revert.Payload)
+    }
+    want := map[string]interface{}{"kept": int64Word(1)}
+    if !reflect.DeepEqual(host.Storage["contract"], want) {
+        t.Errorf("got storage %v after revert, want %v", host.Storage["contract"], want)
+    }
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bonniegachiengu/sustena_platforms/entropy/blockchain"
+	"github.com/bonniegachiengu/sustena_platforms/entropy/state"
+	"github.com/bonniegachiengu/sustena_platforms/symmetry/asm"
+	"github.com/bonniegachiengu/sustena_platforms/symmetry/vm"
+)
+
+// defaultGasLimit is the gas the trace and debug commands run with
+const defaultGasLimit = 1000000
+
+// programFlags are the flags shared by commands that run bytecode
+type programFlags struct {
+	gas   uint64
+	input string
+	state string
+}
+
+func parseProgramFlags(name string, args []string) (*programFlags, []string, error) {
+	flags := &programFlags{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Uint64Var(&flags.gas, "gas", defaultGasLimit, "gas limit")
+	fs.StringVar(&flags.input, "input", "", "string the program reads with INPUT")
+	fs.StringVar(&flags.state, "state", "", "JSON file of chain state to run the program against as a contract")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	return flags, fs.Args(), nil
+}
+
+// readBytecode reads a bytecode file, either raw or hex encoded
+func readBytecode(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, vm.Magic[:]) {
+		text := strings.TrimPrefix(strings.TrimSpace(string(data)), "0x")
+		if data, err = hex.DecodeString(text); err != nil {
+			return nil, fmt.Errorf("%s is neither bytecode nor hex encoded bytecode", path)
+		}
+	}
+	return data, nil
+}
+
+// loadProgram reads a bytecode file into a VM. The VM runs with a mock
+// host, or with the chain's state host if flags name a state file.
+func loadProgram(path string, flags *programFlags) (*vm.VM, error) {
+	code, err := readBytecode(path)
+	if err != nil {
+		return nil, err
+	}
+
+	machine := vm.NewVM()
+	if err := machine.LoadBytecode(code); err != nil {
+		return nil, err
+	}
+	if flags.state == "" {
+		machine.SetHost(vm.NewMockHost("caller", "contract"))
+	} else {
+		host, err := loadStateHost(flags.state, code)
+		if err != nil {
+			return nil, err
+		}
+		machine.SetHost(host)
+	}
+	if flags.input != "" {
+		machine.SetInput(flags.input)
+	}
+	return machine, nil
+}
+
+// stateFile describes the chain state a contract call runs against, so
+// that a transaction that failed on the chain can be reproduced. Storage
+// belongs to the contract being run; its values are written as assembly
+// operands, such as 5 or "alice". Contracts lists bytecode files of other
+// contracts the call may reach.
+type stateFile struct {
+	Caller    string            `json:"caller"`
+	Value     int64             `json:"value"`
+	Balances  map[string]int64  `json:"balances"`
+	Storage   map[string]string `json:"storage"`
+	Contracts []string          `json:"contracts"`
+}
+
+// loadStateHost deploys code to a new chain with the state described by
+// the file at path, and returns a host for calling it the way the
+// executor runs a transaction: the balances and the call's value are
+// transferred in a block, and the contract runs in that block.
+func loadStateHost(path string, code []byte) (*state.Host, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file stateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if file.Caller == "" {
+		file.Caller = "caller"
+	}
+
+	chain := blockchain.NewBlockchain()
+	st := state.NewState(chain)
+	for _, contract := range file.Contracts {
+		other, err := readBytecode(contract)
+		if err != nil {
+			return nil, err
+		}
+		address, err := st.Deploy(other)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", contract, err)
+		}
+		fmt.Fprintf(os.Stderr, "deployed %s at %s\n", contract, address)
+	}
+	address, err := st.Deploy(code)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "running as contract %s called by %s\n", address, file.Caller)
+
+	for key, text := range file.Storage {
+		operand, err := asm.ParseValue(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: storage %s: %w", path, key, err)
+		}
+		value, err := vm.Constant(operand)
+		if err != nil {
+			return nil, fmt.Errorf("%s: storage %s: %w", path, key, err)
+		}
+		st.SetStorage(address, key, value)
+	}
+
+	funded := make([]string, 0, len(file.Balances))
+	for account := range file.Balances {
+		funded = append(funded, account)
+	}
+	sort.Strings(funded)
+	var txs []blockchain.Transaction
+	for i, account := range funded {
+		txs = append(txs, blockchain.Transaction{From: "faucet", To: account, Amount: file.Balances[account], Nonce: int64(i)})
+	}
+	if file.Value > 0 {
+		txs = append(txs, blockchain.Transaction{From: file.Caller, To: address, Amount: file.Value})
+	}
+	last := chain.GetLastBlock()
+	block := blockchain.NewBlock(last.Index+1, txs, last.Hash, "validator", 1)
+	if err := chain.AddBlock(block); err != nil {
+		return nil, err
+	}
+	return state.NewHost(st, block, file.Caller, address), nil
+}
+
+func runAsm(args []string) error {
+	var out string
+	fs := flag.NewFlagSet("asm", flag.ContinueOnError)
+	fs.StringVar(&out, "o", "", "write raw bytecode to this file instead of hex to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: sustena asm [-o file] <source file>")
+	}
+	src, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	code, err := asm.Assemble(string(src))
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+
+	if out == "" {
+		fmt.Println(hex.EncodeToString(code))
+		return nil
+	}
+	return os.WriteFile(out, code, 0o644)
+}
+
+func runDisasm(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: sustena disasm <bytecode file>")
+	}
+	code, err := readBytecode(args[0])
+	if err != nil {
+		return err
+	}
+	text, err := asm.Disassemble(code)
+	if err != nil {
+		return err
+	}
+	fmt.Print(text)
+	return nil
+}
+
+func runTrace(args []string) error {
+	flags, rest, err := parseProgramFlags("trace", args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errors.New("usage: sustena trace [-gas n] [-input s] [-state file] <bytecode file>")
+	}
+	machine, err := loadProgram(rest[0], flags)
+	if err != nil {
+		return err
+	}
+
+	tracer := vm.NewJSONTracer(os.Stdout)
+	machine.SetTracer(tracer)
+	// A failing program is what the trace is for, so its error is in the
+	// output rather than returned
+	machine.Run(flags.gas)
+	return tracer.Err()
+}
+
+const debugHelp = `Commands:
+  s, step            run one instruction
+  c, continue        run to the next breakpoint or the end
+  b, break <pc>      set a breakpoint
+  d, delete <pc>     remove a breakpoint
+  stack              show the stack
+  memory             show global variables
+  locals             show the current function's variables
+  info               show position, gas and breakpoints
+  q, quit            stop debugging`
+
+func runDebug(args []string) error {
+	flags, rest, err := parseProgramFlags("debug", args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return errors.New("usage: sustena debug [-gas n] [-input s] [-state file] <bytecode file>")
+	}
+	machine, err := loadProgram(rest[0], flags)
+	if err != nil {
+		return err
+	}
+	debugger := vm.NewDebugger(machine)
+	if err := debugger.Start(flags.gas); err != nil {
+		return err
+	}
+
+	fmt.Println(debugHelp)
+	printNext(debugger)
+	scanner := bufio.NewScanner(os.Stdin)
+	for fmt.Print("(debug) "); scanner.Scan(); fmt.Print("(debug) ") {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "s", "step", "c", "continue":
+			var step *vm.Step
+			if fields[0] == "s" || fields[0] == "step" {
+				step, err = debugger.Step()
+			} else {
+				step, err = debugger.Continue()
+			}
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			printStep(step)
+			printNext(debugger)
+		case "b", "break", "d", "delete":
+			if len(fields) != 2 {
+				fmt.Println("usage: break|delete <pc>")
+				continue
+			}
+			pc, err := strconv.Atoi(fields[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if fields[0] == "d" || fields[0] == "delete" {
+				debugger.RemoveBreakpoint(pc)
+			} else if err := debugger.AddBreakpoint(pc); err != nil {
+				fmt.Println(err)
+			}
+		case "stack":
+			stack := debugger.Stack()
+			for i := len(stack) - 1; i >= 0; i-- {
+				fmt.Printf("  %d: %s\n", i, vm.FormatValue(stack[i]))
+			}
+		case "memory":
+			printVariables(debugger.Memory())
+		case "locals":
+			printVariables(debugger.Locals())
+		case "info":
+			fmt.Printf("  pc %d, call depth %d, gas used %d\n", debugger.PC(), debugger.CallDepth(), debugger.GasUsed())
+			fmt.Printf("  breakpoints %v\n", debugger.Breakpoints())
+		case "q", "quit":
+			return nil
+		default:
+			fmt.Println(debugHelp)
+		}
+	}
+	return scanner.Err()
+}
+
+func printStep(step *vm.Step) {
+	if step == nil {
+		return
+	}
+	fmt.Printf("  %d: %s (gas %d)\n", step.PC, formatInstruction(step.Op, step.Operand), step.Cost)
+	if step.Err != nil {
+		fmt.Printf("  error: %v\n", step.Err)
+	}
+}
+
+func printNext(debugger *vm.Debugger) {
+	if ins, ok := debugger.Next(); ok {
+		fmt.Printf("next %d: %s\n", debugger.PC(), formatInstruction(ins.OpCode, ins.Operand))
+		return
+	}
+	if err := debugger.Err(); err != nil {
+		fmt.Printf("program failed after %d gas: %v\n", debugger.GasUsed(), err)
+	} else {
+		fmt.Printf("program finished after %d gas\n", debugger.GasUsed())
+	}
+}
+
+func formatInstruction(op vm.OpCode, operand interface{}) string {
+	if operand == nil {
+		return op.String()
+	}
+	return op.String() + " " + vm.FormatValue(operand)
+}
+
+func printVariables(vars map[string]interface{}) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %s = %s\n", name, vm.FormatValue(vars[name]))
+	}
+}